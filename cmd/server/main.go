@@ -2,7 +2,9 @@
 //
 //	@title			Gin SQLite Demo API
 //	@version		1.0
-//	@description	A simple REST API for managing persons using Gin and SQLite
+//	@description	A simple REST API for managing persons using Gin and SQLite.
+//	@description	Responses are documented as v2 serves them. The deprecated v1 routes and /auth/login keep the bodies they had
+//	@description	before the envelope: data and pagination for lists, data, message and error otherwise, and a bare token on login.
 //	@termsOfService	http://swagger.io/terms/
 //
 //	@contact.name	API Support
@@ -20,114 +22,580 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/atrakic/gin-sqlite/internal/api"
 	"github.com/atrakic/gin-sqlite/internal/auth"
+	"github.com/atrakic/gin-sqlite/internal/backup"
+	"github.com/atrakic/gin-sqlite/internal/certs"
+	"github.com/atrakic/gin-sqlite/internal/compression"
+	"github.com/atrakic/gin-sqlite/internal/config"
 	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/atrakic/gin-sqlite/internal/health"
+	"github.com/atrakic/gin-sqlite/internal/logging"
+	"github.com/atrakic/gin-sqlite/internal/maintenance"
+	"github.com/atrakic/gin-sqlite/internal/metrics"
 	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/atrakic/gin-sqlite/internal/openapi"
+	"github.com/atrakic/gin-sqlite/internal/ratelimit"
+	"github.com/atrakic/gin-sqlite/internal/tracing"
+	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/swaggo/files"
-	"github.com/swaggo/gin-swagger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func main() {
-	if err := database.ConnectDatabase(); err != nil {
-		log.Fatal(err)
+	// Subcommands come before the flags
+	args := os.Args[1:]
+	var command, restoreFile string
+	switch {
+	case len(args) >= 2 && args[0] == "config" && args[1] == "print":
+		command, args = "config print", args[2:]
+	case len(args) >= 1 && args[0] == "backup":
+		command, args = "backup", args[1:]
+	case len(args) >= 1 && args[0] == "restore":
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			config.Usage(os.Stderr, os.Args[0])
+			os.Exit(2)
+		}
+		command, restoreFile, args = "restore", args[1], args[2:]
+	}
+
+	cfg, err := config.Load(os.Args[0], args)
+	if errors.Is(err, flag.ErrHelp) {
+		config.Usage(os.Stderr, os.Args[0])
+		return
+	}
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+
+	if command == "config print" {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fatal("Failed to print configuration", err)
+		}
+		return
+	}
+
+	// Validated by config.Load
+	level, _ := cfg.Logging.SlogLevel()
+	logging.Setup(os.Stderr, level)
+
+	if command == "backup" || command == "restore" {
+		runBackupCommand(cfg, command, restoreFile)
+		return
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+
+	auth.Configure(cfg.Auth.JWTSecret, cfg.Auth.AdminUser, cfg.Auth.AdminPassword)
+
+	if err := database.ConnectDatabase(dataSource(cfg)); err != nil {
+		fatal("Failed to open database", err)
 	}
 
 	// Initialize database tables
 	if err := database.InitializeDatabase(); err != nil {
-		log.Fatal("Failed to initialize database:", err)
+		fatal("Failed to initialize database", err)
 	}
 
-	log.Println("Starting server...")
-	r := setupRouter()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Shared by the scheduled backups and the admin API, so they take turns
+	var backups *backup.Manager
+	if cfg.Backup.Enabled() {
+		if backups, err = newBackupManager(cfg.Backup); err != nil {
+			fatal("Failed to set up backups", err)
+		}
+		if cfg.Backup.Interval > 0 {
+			go backups.Run(ctx, cfg.Backup.Interval)
+		}
+	}
+
+	var replicator *backup.Replicator
+	if cfg.Backup.WALEnabled() {
+		if replicator, err = newReplicator(cfg.Backup); err != nil {
+			fatal("Failed to set up WAL replication", err)
+		}
+		if err := replicator.Start(ctx); err != nil {
+			fatal("Failed to start WAL replication", err)
+		}
+		go replicator.Run(ctx, cfg.Backup.WALInterval)
+	}
+
+	scheduler, err := maintenance.NewScheduler(maintenanceJobs(cfg.Maintenance, replicator))
+	if err != nil {
+		fatal("Failed to schedule maintenance jobs", err)
+	}
+	scheduler.Start()
+
+	toggleMaintenanceOnSignal(ctx, cfg.Maintenance.RetryAfter)
+
+	slog.Info("Starting server")
+	r, err := setupRouter(cfg, backups)
+	if err != nil {
+		fatal("Failed to set up the router", err)
+	}
+	setupRoutes(r)
+
+	ln, err := net.Listen("tcp", cfg.Addr())
+	if err != nil {
+		fatal("Failed to listen", err)
+	}
+	srv := newServer(cfg, r)
+
+	if cfg.TLS.Enabled() {
+		tlsConfig, reloader, err := certs.ServerConfig(cfg.TLS)
+		if err != nil {
+			fatal("Failed to set up TLS", err)
+		}
+		go func() {
+			if err := reloader.Watch(ctx); err != nil {
+				slog.Error("Watching TLS certificate failed, it won't be reloaded", "error", err)
+			}
+		}()
+		srv.TLSConfig = tlsConfig
+		ln = tls.NewListener(ln, tlsConfig)
+		slog.Info("Listening and serving HTTPS", "addr", cfg.Addr(), "client_auth", cfg.TLS.ClientAuth)
+	} else {
+		slog.Info("Listening and serving HTTP", "addr", cfg.Addr())
+	}
+
+	if err := serve(ctx, srv, ln, cfg.Server.ShutdownTimeout); err != nil {
+		slog.Error("Server error", "error", err)
+	}
 
-	// Auth endpoints
-	authGroup := r.Group("/auth")
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := scheduler.Stop(ctx); err != nil {
+		slog.Warn("Maintenance jobs still running at shutdown", "error", err)
+	}
+
+	// Ship the last transactions before checkpointing on close
+	if replicator != nil {
+		if err := replicator.Close(ctx); err != nil {
+			slog.Error("Shipping the last WAL frames failed", "error", err)
+		}
+	}
+
+	if err := database.CloseDatabase(); err != nil {
+		fatal("Failed to close database", err)
+	}
+
+	// Flush the spans of the last requests
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Warn("Flushing traces failed", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// runBackupCommand takes a backup, or restores restoreFile, then exits
+func runBackupCommand(cfg *config.Config, command, restoreFile string) {
+	if err := database.ConnectDatabase(cfg.Database.File); err != nil {
+		fatal("Failed to open database", err)
+	}
+	defer func() {
+		if err := database.CloseDatabase(); err != nil {
+			fatal("Failed to close database", err)
+		}
+	}()
+
+	ctx := context.Background()
+	if command == "restore" {
+		if err := restore(ctx, cfg.Backup, restoreFile); err != nil {
+			fatal("Restore failed", err)
+		}
+		return
+	}
+
+	if !cfg.Backup.Enabled() {
+		fatal("Backup failed", fmt.Errorf("backup target %s is not configured", cfg.Backup.Target))
+	}
+	backups, err := newBackupManager(cfg.Backup)
+	if err != nil {
+		fatal("Failed to set up backups", err)
+	}
+	info, err := backups.Create(ctx)
+	if err != nil && info.Name == "" {
+		fatal("Backup failed", err)
+	}
+	if err != nil {
+		slog.Warn("Pruning backups failed", "error", err)
+	}
+	fmt.Println(info.Name)
+}
+
+// restore restores a local backup file, or else the backup of that name
+// stored by the configured target, or the state of the database at an RFC
+// 3339 time from the WAL replica
+func restore(ctx context.Context, cfg config.Backup, name string) error {
+	if _, err := os.Stat(name); err == nil || !cfg.Enabled() {
+		return backup.RestoreFile(ctx, name)
+	}
+	if at, err := time.Parse(time.RFC3339, name); err == nil {
+		replicator, err := newReplicator(cfg)
+		if err != nil {
+			return err
+		}
+		_, err = replicator.Restore(ctx, at)
+		return err
+	}
+	backups, err := newBackupManager(cfg)
+	if err != nil {
+		return err
+	}
+	return backups.Restore(ctx, name)
+}
+
+// dataSource returns the data source of the configured database, prepared
+// for WAL replication when it's enabled
+func dataSource(cfg *config.Config) string {
+	if cfg.Backup.WALEnabled() {
+		return backup.ReplicaDataSource(cfg.Database.File)
+	}
+	return cfg.Database.File
+}
+
+// maintenanceJobs returns the database maintenance jobs on their configured
+// schedules. With WAL replication the replicator checkpoints, so it ships
+// the WAL before it restarts.
+func maintenanceJobs(cfg config.Maintenance, replicator *backup.Replicator) []maintenance.Job {
+	checkpoint := maintenance.Checkpoint
+	if replicator != nil {
+		checkpoint = func(ctx context.Context) (string, error) {
+			return "WAL shipped", replicator.Checkpoint(ctx)
+		}
+	}
+	return []maintenance.Job{
+		{Name: maintenance.JobCheckpoint, Schedule: cfg.Checkpoint, Run: checkpoint},
+		{Name: maintenance.JobVacuum, Schedule: cfg.Vacuum, Run: maintenance.Vacuum},
+		{Name: maintenance.JobOptimize, Schedule: cfg.Optimize, Run: maintenance.Optimize},
+		{Name: maintenance.JobIntegrityCheck, Schedule: cfg.IntegrityCheck, Run: maintenance.IntegrityCheck},
+		{Name: maintenance.JobPurgeDeleted, Schedule: cfg.PurgeDeleted, Run: maintenance.PurgeDeleted(cfg.DeletedRetention)},
+	}
+}
+
+// newBackupManager creates the backup manager for the configured target
+func newBackupManager(cfg config.Backup) (*backup.Manager, error) {
+	target, err := newBackupTarget(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return backup.NewManager(target, cfg.Compression, backup.Retention{
+		Hourly: cfg.KeepHourly,
+		Daily:  cfg.KeepDaily,
+	}), nil
+}
+
+// newReplicator creates the WAL replicator for the configured target
+func newReplicator(cfg config.Backup) (*backup.Replicator, error) {
+	target, err := newBackupTarget(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return backup.NewReplicator(target, cfg.Compression, cfg.WALRetention), nil
+}
+
+// newBackupTarget creates the configured backup target
+func newBackupTarget(cfg config.Backup) (backup.Target, error) {
+	switch cfg.Target {
+	case config.TargetS3:
+		s3, err := backup.NewS3Target(backup.S3Options{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			Prefix:    cfg.Prefix,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			Insecure:  cfg.S3Insecure,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return s3, nil
+	case config.TargetAzure:
+		azure, err := backup.NewAzureTarget(backup.AzureOptions{
+			Account:   cfg.AzureAccount,
+			Key:       cfg.AzureKey,
+			Endpoint:  cfg.AzureEndpoint,
+			Container: cfg.AzureContainer,
+			Prefix:    cfg.Prefix,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return azure, nil
+	default:
+		return backup.NewDirTarget(cfg.Dir), nil
+	}
+}
+
+// v1 is deprecated in favour of v2 and will be removed after the sunset date
+var (
+	v1Deprecation = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	v1Sunset      = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// setupRoutes registers the auth and versioned API routes
+func setupRoutes(r *gin.Engine) {
+	// Auth endpoints, the v1 login predates the versioned groups
+	authGroup := r.Group("/auth", api.Version(api.V1), api.Deprecated(v1Deprecation, v1Sunset, "/auth", "/api/v2/auth"))
 	{
 		authGroup.POST("/login", api.Login)
 	}
 
-	v1 := r.Group("/api/v1")
-	{
-		v1.GET("person", api.GetPersons)
-		v1.GET("person/:id", api.GetPersonByID)
+	v1 := r.Group("/api/v1", api.Version(api.V1), api.Deprecated(v1Deprecation, v1Sunset, "/api/v1", "/api/v2"))
+	personRoutes(v1)
 
-		// Needs JWT authentication
-		v1.POST("person", jwtAuth, api.AddPerson)
-		v1.PUT("person/:id", jwtAuth, api.UpdatePerson)
-		v1.DELETE("person/:id", jwtAuth, api.DeletePerson)
-	}
+	v2 := r.Group("/api/v2", api.Version(api.V2))
+	v2.POST("auth/login", api.Login)
+	personRoutes(v2)
+}
 
-	_ = r.Run()
+// personRoutes registers the person handlers, shared by all API versions
+func personRoutes(g *gin.RouterGroup) {
+	g.GET("person", adminForDeleted, api.GetPersons)
+	g.GET("person.vcf", api.ExportPersonsVCard)
+	g.GET("person/:id", adminForDeleted, api.GetPersonByID)
+	g.GET("person/:id/history", adminForDeleted, api.GetPersonHistory)
+
+	// Needs JWT authentication; writes check the Accept header first, as
+	// answering 406 after writing would hide that the write happened
+	g.POST("person", api.Acceptable, jwtAuth, api.RejectInMaintenance, api.AddPerson)
+	g.POST("person.vcf", api.Acceptable, jwtAuth, api.RejectInMaintenance, api.ImportPersonsVCard)
+	g.PUT("person/:id", api.Acceptable, jwtAuth, api.RejectInMaintenance, api.UpdatePerson)
+	g.DELETE("person/:id", api.Acceptable, jwtAuth, api.RejectInMaintenance, api.DeletePerson)
+	g.POST("person/:id/restore", api.Acceptable, jwtAuth, api.RejectInMaintenance, api.RestorePerson)
+	g.POST("person/:id/history/:revision/revert", api.Acceptable, jwtAuth, api.RejectInMaintenance, api.RevertPerson)
+	g.GET("changes", jwtAuth, api.GetPersonChanges)
 }
 
-func setupRouter() *gin.Engine {
-	r := gin.Default()
+// setupRouter creates the engine with its middleware and the routes outside
+// the API versions; the backup routes use backups and are left out when nil
+func setupRouter(cfg *config.Config, backups *backup.Manager) (*gin.Engine, error) {
+	r := gin.New()
+	// Rate limits key anonymous clients by IP, only the configured proxies
+	// may set it with X-Forwarded-For
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("setting trusted proxies: %w", err)
+	}
+	r.Use(otelgin.Middleware(cfg.Tracing.ServiceName, otelgin.WithGinFilter(traced)))
+	r.Use(logging.Middleware())
+	r.Use(gin.CustomRecoveryWithWriter(io.Discard, recovered))
+	r.Use(metrics.Middleware())
+	r.Use(api.SecurityHeaders(cfg.Security.HSTSMaxAge, cfg.Security.ContentSecurityPolicy))
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		// Before rate limiting, so preflight requests don't use up tokens
+		r.Use(cors.New(corsConfig(cfg.CORS)))
+	}
+	r.Use(api.RateLimit(newLimiter(cfg), cfg.RateLimit.APIKeyHeader))
+	r.Use(compression.Middleware(cfg.Compression.Encodings, cfg.Compression.MinSize))
+	r.Use(api.DecompressBody(), api.MaxBodySize(int64(cfg.Server.MaxBodyBytes)))
 
-	// Serve only swagger.json file
-	r.GET("/docs/swagger.json", func(c *gin.Context) {
-		c.File("./docs/swagger.json")
-	})
+	// OpenAPI spec and Swagger UI, both embedded in the binary
+	if cfg.Docs.SwaggerFile != "" {
+		r.GET(openapi.SpecPath, func(c *gin.Context) {
+			c.File(cfg.Docs.SwaggerFile)
+		})
+	} else {
+		r.GET(openapi.SpecPath, openapi.SpecHandler())
+	}
+	r.GET("/swagger/*any", openapi.UIHandler())
 
-	// Swagger endpoint
-	r.GET("/swagger/*any",
-		ginSwagger.WrapHandler(swaggerFiles.Handler,
-			ginSwagger.URL("/docs/swagger.json")))
+	// Prometheus metrics, including deprecated API usage
+	r.GET("/metrics", metrics.Handler())
 
 	// PingHandler handles the ping endpoint
 	// @Summary Health check endpoint
 	// @Description Returns a pong message with timestamp
 	// @Tags health
 	// @Accept json
-	// @Produce json
-	// @Success 200 {object} models.HealthCheckResponse "Pong response with timestamp"
+	// @Produce json,xml,application/x-yaml,application/x-msgpack
+	// @Success 200 {object} models.APIResponse "Pong response with timestamp"
 	// @Router /ping [get]
 	r.GET("/ping", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"message": "pong " + fmt.Sprint(time.Now().Unix())})
+		api.Render(c, http.StatusOK, models.APIResponse{Message: "pong " + fmt.Sprint(time.Now().Unix())})
 	})
-	return r
+
+	// Admin only database maintenance and backups
+	admin := r.Group("/admin", api.Acceptable, jwtAuth, adminOnly)
+	admin.GET("/jobs", api.ListJobRuns)
+	admin.GET("/maintenance", api.GetMaintenance)
+	admin.POST("/maintenance", api.EnterMaintenance(cfg.Maintenance.RetryAfter))
+	admin.DELETE("/maintenance", api.LeaveMaintenance)
+	if backups != nil {
+		admin.GET("/backups", api.ListBackups(backups))
+		admin.POST("/backups", api.CreateBackup(backups))
+		admin.POST("/backups/:name/restore", api.RestoreBackup(backups, cfg.Maintenance.RetryAfter))
+	}
+
+	// Kubernetes style liveness and readiness probes
+	r.GET("/healthz", api.Healthz)
+	r.GET("/readyz", api.Readyz(&health.Checker{
+		Checks:  database.HealthChecks(uint64(cfg.Health.MinDiskFreeMB)<<20, uint64(cfg.Health.MaxWALSizeMB)<<20),
+		Timeout: cfg.Health.Timeout,
+	}))
+	return r, nil
+}
+
+// corsConfig maps the configured CORS policy, validated by config.Load
+func corsConfig(cfg config.CORS) cors.Config {
+	c := cors.Config{
+		AllowMethods:     cfg.AllowedMethods,
+		AllowHeaders:     cfg.AllowedHeaders,
+		AllowCredentials: cfg.AllowCredentials,
+		ExposeHeaders:    api.ExposedHeaders,
+		MaxAge:           cfg.MaxAge,
+	}
+	if slices.Contains(cfg.AllowedOrigins, "*") {
+		c.AllowAllOrigins = true
+	} else {
+		c.AllowOrigins = cfg.AllowedOrigins
+	}
+	return c
+}
+
+// newLimiter creates the rate limiter, probes and scrapes are not limited
+func newLimiter(cfg *config.Config) *ratelimit.Limiter {
+	// Validated by config.Load
+	def, routes, _ := cfg.RateLimit.Limits()
+	for _, path := range probePaths {
+		routes[http.MethodGet+" "+path] = ratelimit.Limit{}
+	}
+
+	var store ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimit.Store == config.StoreSQLite {
+		store = database.NewRateLimitStore()
+	}
+	return ratelimit.NewLimiter(store, def, routes)
+}
+
+// recovered logs a panic in a handler and answers 500
+func recovered(c *gin.Context, err any) {
+	slog.ErrorContext(c.Request.Context(), "Panic serving request", "error", err, "stack", string(debug.Stack()))
+	c.AbortWithStatus(http.StatusInternalServerError)
+}
+
+// probePaths are polled by orchestrators and scrapers rather than clients
+var probePaths = []string{"/metrics", "/healthz", "/readyz"}
+
+// traced leaves probe and scrape requests out of traces
+func traced(c *gin.Context) bool {
+	return !slices.Contains(probePaths, c.FullPath())
 }
 
-// jwtAuth validates JWT tokens from Authorization header
+// jwtAuth validates JWT tokens from Authorization header, unless the client
+// authenticated with a verified TLS certificate
 func jwtAuth(c *gin.Context) {
+	if username, ok := authenticate(c); ok {
+		authenticated(c, username)
+	}
+}
+
+// authenticate returns the user authenticated by a verified TLS certificate
+// or else a JWT token, answering 401 and aborting when there is none
+func authenticate(c *gin.Context) (string, bool) {
+	if username, ok := certs.ClientUsername(c.Request.TLS); ok {
+		return username, true
+	}
+
 	authHeader := c.GetHeader("Authorization")
 	if authHeader == "" {
-		c.JSON(http.StatusUnauthorized, models.APIResponse{
+		api.Render(c, http.StatusUnauthorized, models.APIResponse{
 			Error: "Authorization header required",
 		})
 		c.Abort()
-		return
+		return "", false
 	}
 
 	// Check for Bearer token format
 	tokenParts := strings.SplitN(authHeader, " ", 2)
 	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-		c.JSON(http.StatusUnauthorized, models.APIResponse{
+		api.Render(c, http.StatusUnauthorized, models.APIResponse{
 			Error: "Authorization header must be Bearer token",
 		})
 		c.Abort()
-		return
+		return "", false
 	}
 
 	// Validate JWT token
 	claims, err := auth.ValidateJWT(tokenParts[1])
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, models.APIResponse{
+		api.Render(c, http.StatusUnauthorized, models.APIResponse{
 			Error: "Invalid or expired token",
 		})
 		c.Abort()
+		return "", false
+	}
+
+	return claims.Username, true
+}
+
+// adminOnly lets only the admin user through, after jwtAuth
+func adminOnly(c *gin.Context) {
+	if !auth.IsAdmin(c.GetString("username")) {
+		api.Render(c, http.StatusForbidden, models.APIResponse{
+			Error: "Admin access required",
+		})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// adminForDeleted lets only the admin user see deleted persons with
+// ?include_deleted=true; other requests pass unauthenticated
+func adminForDeleted(c *gin.Context) {
+	if include, _ := strconv.ParseBool(c.Query("include_deleted")); !include {
+		c.Next()
 		return
 	}
 
-	// Set user context for use in handlers
-	c.Set("username", claims.Username)
-	log.Printf("User authenticated: %s", claims.Username)
+	username, ok := authenticate(c)
+	if !ok {
+		return
+	}
+	if !auth.IsAdmin(username) {
+		api.Render(c, http.StatusForbidden, models.APIResponse{
+			Error: "Admin access required",
+		})
+		c.Abort()
+		return
+	}
+	authenticated(c, username)
+}
+
+// authenticated sets the user context for use in handlers and logs
+func authenticated(c *gin.Context, username string) {
+	c.Set("username", username)
+	logging.SetUsername(c.Request.Context(), username)
+	slog.DebugContext(c.Request.Context(), "User authenticated")
 	c.Next()
 }
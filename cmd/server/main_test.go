@@ -2,82 +2,85 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/atrakic/gin-sqlite/internal/api"
 	"github.com/atrakic/gin-sqlite/internal/auth"
+	"github.com/atrakic/gin-sqlite/internal/backup"
+	"github.com/atrakic/gin-sqlite/internal/compression"
+	"github.com/atrakic/gin-sqlite/internal/config"
 	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/atrakic/gin-sqlite/internal/health"
+	"github.com/atrakic/gin-sqlite/internal/logging"
+	"github.com/atrakic/gin-sqlite/internal/maintenance"
 	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/atrakic/gin-sqlite/internal/tracing"
 	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // Test configuration
 const (
 	testAdminUser     = "admin"
 	testAdminPassword = "secret"
-	testDatabaseFile  = ":memory:"
 )
 
-// setupTestEnv sets up all required environment variables for testing
-func setupTestEnv(t *testing.T) {
-	t.Setenv("DATABASE_FILE", testDatabaseFile)
-	t.Setenv("ADMIN_USER", testAdminUser)
-	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
-	t.Setenv("JWT_SECRET", "test-secret-key-for-jwt-testing")
+// setupTestAuth configures the admin credentials and JWT secret for testing
+func setupTestAuth(t *testing.T) {
+	auth.Configure("test-secret-key-for-jwt-testing", testAdminUser, testAdminPassword)
+	t.Cleanup(func() {
+		defaults := config.Default()
+		auth.Configure(defaults.Auth.JWTSecret, defaults.Auth.AdminUser, defaults.Auth.AdminPassword)
+	})
 }
 
-// setupTestDatabase creates an in-memory SQLite database for testing
+// setupTestDatabase creates a temporary SQLite database for testing
 func setupTestDatabase(t *testing.T) {
-	setupTestEnv(t)
+	setupTestAuth(t)
 
-	// Connect to the test database
-	err := database.ConnectDatabase()
+	// A file rather than :memory: so every pooled connection sees the same data
+	err := database.ConnectDatabase(filepath.Join(t.TempDir(), "test.db"))
 	require.NoError(t, err, "Failed to connect to test database")
+	t.Cleanup(func() { _ = database.CloseDatabase() })
 
-	// Create the people table
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS people (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		first_name TEXT NOT NULL,
-		last_name TEXT NOT NULL,
-		email TEXT NOT NULL UNIQUE
-	);`
-
-	_, err = database.DB.Exec(createTableSQL)
-	require.NoError(t, err, "Failed to create people table")
-
-	// Insert test data
-	insertSQL := `
-	INSERT INTO people (first_name, last_name, email) VALUES
-	('John', 'Doe', 'john.doe@example.com'),
-	('Jane', 'Smith', 'jane.smith@example.com'),
-	('Bob', 'Johnson', 'bob.johnson@example.com');`
-
-	_, err = database.DB.Exec(insertSQL)
-	require.NoError(t, err, "Failed to insert test data")
+	// Create the schema and the sample persons John, Jane and Bob
+	err = database.InitializeDatabase()
+	require.NoError(t, err, "Failed to initialize test database")
 }
 
 // setupTestRouter creates a router with test database and API routes
 func setupTestRouter() *gin.Engine {
 	gin.SetMode(gin.TestMode)
-	r := setupRouter()
-
-	// Add the API routes like in main.go
-	v1 := r.Group("/api/v1")
-	{
-		v1.GET("person", api.GetPersons)
-		v1.GET("person/:id", api.GetPersonByID)
-
-		// Needs authentication
-		v1.POST("person", jwtAuth, api.AddPerson)
-		v1.PUT("person/:id", jwtAuth, api.UpdatePerson)
-		v1.DELETE("person/:id", jwtAuth, api.DeletePerson)
+	r, err := setupRouter(config.Default(), nil)
+	if err != nil {
+		panic("Failed to set up the router: " + err.Error())
 	}
+	setupRoutes(r)
 
 	return r
 }
@@ -133,9 +136,10 @@ func TestGetPersons(t *testing.T) {
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	require.NoError(t, err)
 
-	// Check paginated response structure
+	// Check paginated response structure, which v1 keeps from before the envelope
 	assert.Contains(t, response, "data")
 	assert.Contains(t, response, "pagination")
+	assert.NotContains(t, response, "meta")
 
 	data := response["data"].([]interface{})
 	assert.True(t, len(data) >= 0, "Should return data array")
@@ -154,6 +158,29 @@ func TestGetPersons(t *testing.T) {
 	assert.Equal(t, float64(10), pagination["page_size"])
 }
 
+func TestGetPersonsEnvelope(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v2/person", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Contains(t, response, "data")
+	assert.Contains(t, response, "links")
+	assert.NotContains(t, response, "pagination")
+
+	meta := response["meta"].(map[string]interface{})
+	assert.Equal(t, "v2", meta["api_version"])
+	pagination := meta["pagination"].(map[string]interface{})
+	assert.Equal(t, float64(1), pagination["current_page"])
+	assert.Equal(t, float64(10), pagination["page_size"])
+}
+
 func TestGetPersonsPagination(t *testing.T) {
 	setupTestDatabase(t)
 	router := setupTestRouter()
@@ -309,6 +336,23 @@ func TestDeletePersonWithAuth(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "deleted")
 }
 
+func TestDeletePersonHidesDatabaseErrors(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	_, err := database.DB.Exec(`CREATE TRIGGER fail_delete BEFORE UPDATE ON people
+		BEGIN SELECT RAISE(ABORT, 'internal storage detail'); END`)
+	require.NoError(t, err)
+
+	for _, path := range []string{"/api/v1/person/3", "/api/v2/person/3"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, makeAuthenticatedRequest("DELETE", path, nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code, path)
+		assert.JSONEq(t, `"Failed to delete person"`, mustJSON(t, w.Body.Bytes(), "error"), path)
+		assert.NotContains(t, w.Body.String(), "storage detail", path)
+	}
+}
+
 func TestAddPersonInvalidJSON(t *testing.T) {
 	setupTestDatabase(t)
 	router := setupTestRouter()
@@ -325,3 +369,1557 @@ func TestAddPersonInvalidJSON(t *testing.T) {
 
 	assert.Contains(t, response, "error")
 }
+
+func TestGetPersonVCard(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/person/1.vcf", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/vcard")
+	assert.Contains(t, w.Body.String(), "FN:John Doe\r\n")
+	assert.Contains(t, w.Body.String(), "EMAIL:john.doe@example.com\r\n")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/person/999.vcf", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestVCardExportImportRoundTrip(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/person.vcf", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	exported := w.Body.String()
+	assert.Equal(t, 3, strings.Count(exported, "BEGIN:VCARD"))
+
+	// Re-importing the export skips every card as the emails already exist
+	w = httptest.NewRecorder()
+	req = makeAuthenticatedRequest("POST", "/api/v1/person.vcf", []byte(exported))
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"imported": 0, "skipped": 3}`, mustJSON(t, w.Body.Bytes(), "data"))
+
+	// Importing into an empty table recreates the same persons
+	_, err := database.DB.Exec("DELETE FROM people")
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	req = makeAuthenticatedRequest("POST", "/api/v1/person.vcf", []byte(exported))
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"imported": 3, "skipped": 0}`, mustJSON(t, w.Body.Bytes(), "data"))
+
+	persons, err := database.DbGetAllPersons(context.Background())
+	require.NoError(t, err)
+	require.Len(t, persons, 3)
+	assert.Equal(t, "John", persons[0].FirstName)
+	assert.Equal(t, "Doe", persons[0].LastName)
+	assert.Equal(t, "john.doe@example.com", persons[0].Email)
+}
+
+func TestImportPersonsVCardInvalid(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req := makeAuthenticatedRequest("POST", "/api/v1/person.vcf", []byte("BEGIN:VCARD\r\nFN:Broken\r\n"))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/person.vcf", strings.NewReader(""))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestImportPersonsVCardStopsOnError(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	// Fail the second card with an error other than a duplicate email
+	_, err := database.DB.Exec(`CREATE TRIGGER fail_import BEFORE INSERT ON people
+		WHEN NEW.email = 'second@example.com' BEGIN SELECT RAISE(ABORT, 'storage failure'); END`)
+	require.NoError(t, err)
+
+	cards := "BEGIN:VCARD\r\nVERSION:4.0\r\nFN:John Doe\r\nEMAIL:john.doe@example.com\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:4.0\r\nFN:First Card\r\nEMAIL:first@example.com\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Second Card\r\nEMAIL:second@example.com\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Third Card\r\nEMAIL:third@example.com\r\nEND:VCARD\r\n"
+
+	w := httptest.NewRecorder()
+	req := makeAuthenticatedRequest("POST", "/api/v2/person.vcf", []byte(cards))
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"imported": 1, "skipped": 1}`, mustJSON(t, w.Body.Bytes(), "data"))
+	assert.Contains(t, mustJSON(t, w.Body.Bytes(), "error"), "after 1 of 4 persons")
+
+	// The card imported before the failure is kept, none after it is tried
+	persons, err := database.DbGetAllPersons(context.Background())
+	require.NoError(t, err)
+	require.Len(t, persons, 4)
+	assert.Equal(t, "first@example.com", persons[3].Email)
+}
+
+// mustJSON returns the given top-level field of a JSON body re-encoded as JSON
+func mustJSON(t *testing.T, body []byte, field string) string {
+	var response map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(body, &response))
+	return string(response[field])
+}
+
+func TestGetPersonsContentNegotiation(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	tests := []struct {
+		accept      string
+		contentType string
+		contains    string
+	}{
+		{"application/json", "application/json", `"first_name":"John"`},
+		{"application/xml", "application/xml", "<first_name>John</first_name>"},
+		{"text/xml", "application/xml", "<first_name>John</first_name>"},
+		{"application/yaml", "application/yaml", "first_name: John"},
+		{"application/x-msgpack", "application/msgpack", "John"},
+		{"*/*", "application/json", `"first_name":"John"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v1/person", nil)
+			req.Header.Set("Accept", tt.accept)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Header().Get("Content-Type"), tt.contentType)
+			assert.Contains(t, w.Body.String(), tt.contains)
+		})
+	}
+}
+
+func TestGetPersonMsgPack(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/person/1", nil)
+	req.Header.Set("Accept", "application/msgpack")
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Data models.Person `json:"data"`
+	}
+	require.NoError(t, codec.NewDecoderBytes(w.Body.Bytes(), &codec.MsgpackHandle{}).Decode(&response))
+	assert.Equal(t, "John", response.Data.FirstName)
+	assert.Equal(t, "john.doe@example.com", response.Data.Email)
+}
+
+func TestNotAcceptable(t *testing.T) {
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/ping", nil)
+	req.Header.Set("Accept", "text/csv")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	assert.Contains(t, w.Body.String(), "Not acceptable")
+}
+
+func TestNotAcceptableWritesNothing(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	person, _ := json.Marshal(createTestPerson("Alice", "Wonder", "alice.wonder@example.com"))
+	for _, req := range []*http.Request{
+		makeAuthenticatedRequest("POST", "/api/v2/person", person),
+		makeAuthenticatedRequest("DELETE", "/api/v2/person/1", nil),
+		makeAuthenticatedRequest("POST", "/admin/maintenance", nil),
+	} {
+		req.Header.Set("Accept", "text/csv")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotAcceptable, w.Code, req.URL.Path)
+	}
+
+	count, err := database.DbGetPersonsCount(t.Context(), database.PersonFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	on, _ := database.InMaintenance()
+	assert.False(t, on)
+}
+
+func TestAddPersonRequestFormats(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	tests := []struct {
+		contentType string
+		body        string
+	}{
+		{"application/xml", "<Person><first_name>Xavier</first_name><last_name>Ml</last_name><email>xml@example.com</email></Person>"},
+		{"application/x-yaml", "first_name: Yolanda\nlast_name: Aml\nemail: yaml@example.com\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := makeAuthenticatedRequest("POST", "/api/v1/person", []byte(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+		})
+	}
+
+	var body []byte
+	person := createTestPerson("Mia", "Pack", "msgpack@example.com")
+	require.NoError(t, codec.NewEncoderBytes(&body, &codec.MsgpackHandle{}).Encode(person))
+
+	w := httptest.NewRecorder()
+	req := makeAuthenticatedRequest("POST", "/api/v1/person", body)
+	req.Header.Set("Content-Type", "application/x-msgpack")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	count, err := database.DbGetPersonsCount(context.Background(), database.PersonFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), count)
+}
+
+func TestAddPersonUnsupportedMediaType(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req := makeAuthenticatedRequest("POST", "/api/v1/person", []byte("first_name=Alice"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestGetPersonsSparseFields(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/person?fields=first_name,id&page_size=2", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"id": 1, "first_name": "John"}, {"id": 2, "first_name": "Jane"}]`, mustJSON(t, w.Body.Bytes(), "data"))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/person?fields=last_name", nil)
+	req.Header.Set("Accept", "application/xml")
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<data><last_name>Doe</last_name></data>")
+}
+
+func TestGetPersonByIDSparseFields(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/person/2?fields=email", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"email": "jane.smith@example.com"}`, mustJSON(t, w.Body.Bytes(), "data"))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/person/999?fields=email", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSparseFieldsInvalid(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	for _, url := range []string{
+		"/api/v1/person?fields=password",
+		"/api/v1/person/1?fields=id%3Bdrop%20table%20people",
+		"/api/v1/person?include=addresses",
+		"/api/v1/person/1?include=addresses",
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, url)
+	}
+}
+
+func TestGetPersonsLinks(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v2/person?page=2&page_size=1&fields=id", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"self": "/api/v2/person?fields=id&page=2&page_size=1",
+		"first": "/api/v2/person?fields=id&page=1&page_size=1",
+		"prev": "/api/v2/person?fields=id&page=1&page_size=1",
+		"next": "/api/v2/person?fields=id&page=3&page_size=1",
+		"last": "/api/v2/person?fields=id&page=3&page_size=1"
+	}`, mustJSON(t, w.Body.Bytes(), "links"))
+
+	link := strings.Join(w.Header().Values("Link"), ", ")
+	assert.Contains(t, link, `</api/v2/person?fields=id&page=3&page_size=1>; rel="next"`)
+	assert.Contains(t, link, `</api/v2/person?fields=id&page=1&page_size=1>; rel="prev"`)
+	assert.Contains(t, link, `</api/v2/person?fields=id&page=2&page_size=1>; rel="self"`)
+
+	// v1 only gets the header, its body keeps the shape it had before links
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/person?page=2&page_size=1&fields=id", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, mustJSON(t, w.Body.Bytes(), "links"))
+	assert.Contains(t, strings.Join(w.Header().Values("Link"), ", "), `</api/v1/person?fields=id&page=3&page_size=1>; rel="next"`)
+}
+
+func TestResponseEnvelope(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	newPerson := createTestPerson("Alice", "Wonder", "alice.wonder@example.com")
+	jsonData, err := json.Marshal(newPerson)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"get", httptest.NewRequest("GET", "/api/v2/person/1", nil)},
+		{"not found", httptest.NewRequest("GET", "/api/v2/person/999", nil)},
+		{"create", makeAuthenticatedRequest("POST", "/api/v2/person", jsonData)},
+		{"update", makeAuthenticatedRequest("PUT", "/api/v2/person/1", jsonData)},
+		{"delete", makeAuthenticatedRequest("DELETE", "/api/v2/person/2", nil)},
+		{"unauthorized", httptest.NewRequest("DELETE", "/api/v2/person/2", nil)},
+		{"login", httptest.NewRequest("POST", "/api/v2/auth/login", strings.NewReader(`{"username": "admin", "password": "wrong"}`))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, tt.req)
+
+			var response models.APIResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, api.V2, response.Meta.APIVersion)
+			assert.True(t, response.Data != nil || response.Message != "" || response.Error != "")
+		})
+	}
+
+	// Unversioned routes answer with the envelope too
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/ping", nil))
+	var response models.APIResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, api.V1, response.Meta.APIVersion)
+	assert.Contains(t, response.Message, "pong")
+}
+
+func TestV1Bodies(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	newPerson := createTestPerson("Alice", "Wonder", "alice.wonder@example.com")
+	jsonData, err := json.Marshal(newPerson)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		keys []string
+	}{
+		{"list", httptest.NewRequest("GET", "/api/v1/person", nil), []string{"data", "pagination"}},
+		{"get", httptest.NewRequest("GET", "/api/v1/person/1", nil), []string{"data"}},
+		{"not found", httptest.NewRequest("GET", "/api/v1/person/999", nil), []string{"error"}},
+		{"create", makeAuthenticatedRequest("POST", "/api/v1/person", jsonData), []string{"data", "message"}},
+		{"update", makeAuthenticatedRequest("PUT", "/api/v1/person/3", []byte(`{"first_name":"Bob","last_name":"J","email":"bob.j@example.com"}`)), []string{"message"}},
+		{"delete", makeAuthenticatedRequest("DELETE", "/api/v1/person/2", nil), []string{"message"}},
+		{"unauthorized", httptest.NewRequest("DELETE", "/api/v1/person/2", nil), []string{"error"}},
+		{"login", httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username": "admin", "password": "secret"}`)), []string{"token", "expires_at"}},
+		{"failed login", httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username": "admin", "password": "wrong"}`)), []string{"error"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, tt.req)
+
+			var response map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.ElementsMatch(t, tt.keys, slices.Collect(maps.Keys(response)), w.Body.String())
+		})
+	}
+}
+
+func TestAddPersonReturnsCreatedPerson(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	newPerson := createTestPerson("Alice", "Wonder", "alice.wonder@example.com")
+	jsonData, err := json.Marshal(newPerson)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req := makeAuthenticatedRequest("POST", "/api/v1/person", jsonData)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var created models.Person
+	require.NoError(t, json.Unmarshal([]byte(mustJSON(t, w.Body.Bytes(), "data")), &created))
+	assert.Equal(t, uint64(4), created.ID)
+	assert.Equal(t, "alice.wonder@example.com", created.Email)
+	assert.Equal(t, "admin", created.CreatedBy)
+	assert.WithinDuration(t, time.Now(), created.CreatedAt, time.Minute)
+	assert.Equal(t, created.CreatedAt, created.UpdatedAt)
+	assert.Empty(t, mustJSON(t, w.Body.Bytes(), "links"), "only v2 has links in the body")
+	assert.Contains(t, w.Header().Values("Link"), `</api/v1/person/4>; rel="self"`)
+}
+
+func TestV1DeprecationHeaders(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/person/1", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "@1792368000", w.Header().Get("Deprecation"))
+	assert.Equal(t, "Fri, 30 Apr 2027 00:00:00 GMT", w.Header().Get("Sunset"))
+	assert.Contains(t, w.Header().Values("Link"), `</api/v2/person/1>; rel="successor-version"`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v2/person/1", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Deprecation"))
+	assert.Empty(t, w.Header().Get("Sunset"))
+	var response models.APIResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, api.V2, response.Meta.APIVersion)
+
+	families := scrapeMetrics(t, router)
+	assert.Positive(t, counterValue(families["api_deprecated_requests_total"], "GET", "/api/v1/person/:id"))
+	assert.Zero(t, counterValue(families["api_deprecated_requests_total"], "GET", "/api/v2/person/:id"))
+}
+
+func TestV2StatusCodes(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	newPerson := createTestPerson("Alice", "Wonder", "alice.wonder@example.com")
+	jsonData, err := json.Marshal(newPerson)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/api/v2/person", jsonData))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "/api/v2/person/4", w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/api/v2/person", jsonData))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("PUT", "/api/v2/person/1", jsonData))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("PUT", "/api/v2/person/999", []byte(`{"first_name":"No","last_name":"One","email":"no.one@example.com"}`)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("DELETE", "/api/v2/person/999", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("DELETE", "/api/v2/person/4", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// v1 keeps answering 200 for the same requests
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("DELETE", "/api/v1/person/999", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("done"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, newServer(config.Default(), handler), ln, 5*time.Second)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- result{string(body), err}
+	}()
+
+	<-started
+	cancel()
+
+	res := <-responses
+	require.NoError(t, res.err)
+	assert.Equal(t, "done", res.body)
+	require.NoError(t, <-served)
+
+	_, err = http.Get("http://" + ln.Addr().String())
+	assert.Error(t, err, "server should no longer accept connections")
+}
+
+func TestHealthz(t *testing.T) {
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, mustJSON(t, w.Body.Bytes(), "data"), `"status":"ok"`)
+}
+
+func TestReadyz(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Data health.Report `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, health.StatusOK, response.Data.Status)
+
+	names := make([]string, 0, len(response.Data.Checks))
+	for _, check := range response.Data.Checks {
+		names = append(names, check.Name)
+		assert.Equal(t, health.StatusOK, check.Status, check.Name)
+		assert.GreaterOrEqual(t, check.LatencyMs, 0.0)
+	}
+	assert.Equal(t, []string{"database", "schema_version", "disk_space", "wal_size", "maintenance"}, names)
+}
+
+func TestReadyzSchemaMismatch(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	_, err := database.DB.Exec("PRAGMA user_version = 99")
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "schema version 99")
+}
+
+func TestMetrics(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person/1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username": "admin", "password": "wrong"}`)))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	families := scrapeMetrics(t, router)
+
+	assert.Positive(t, counterValue(families["http_requests_total"], "GET", "/api/v2/person/:id", "200"))
+	assert.Positive(t, histogramCount(families["http_request_duration_seconds"], "GET", "/api/v2/person/:id"))
+	assert.Positive(t, histogramCount(families["db_query_duration_seconds"], "get_person_by_id"))
+	assert.Positive(t, counterValue(families["auth_logins_total"], "failure"))
+
+	require.Contains(t, families, "db_rows")
+	assert.Equal(t, 3.0, families["db_rows"].GetMetric()[0].GetGauge().GetValue())
+	assert.Contains(t, families, "go_sql_open_connections")
+	assert.Contains(t, families, "go_goroutines")
+}
+
+// scrapeMetrics fetches /metrics and parses the text exposition format
+func scrapeMetrics(t *testing.T, router http.Handler) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(w.Body)
+	require.NoError(t, err)
+	return families
+}
+
+// findMetric returns the metric of family whose label values, in label
+// order, equal values
+func findMetric(family *dto.MetricFamily, values ...string) *dto.Metric {
+	for _, m := range family.GetMetric() {
+		if len(m.GetLabel()) != len(values) {
+			continue
+		}
+		match := true
+		for i, label := range m.GetLabel() {
+			if label.GetValue() != values[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return m
+		}
+	}
+	return nil
+}
+
+func counterValue(family *dto.MetricFamily, values ...string) float64 {
+	return findMetric(family, values...).GetCounter().GetValue()
+}
+
+func histogramCount(family *dto.MetricFamily, values ...string) uint64 {
+	return findMetric(family, values...).GetHistogram().GetSampleCount()
+}
+
+func TestTracing(t *testing.T) {
+	setupTestDatabase(t)
+
+	_, err := tracing.Setup(context.Background(), config.Default().Tracing)
+	require.NoError(t, err)
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+
+	router := setupTestRouter()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v2/person/1", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	query, request := spans[0], spans[1]
+
+	assert.Equal(t, "GET /api/v2/person/:id", request.Name())
+	assert.Equal(t, traceID, request.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", request.Parent().SpanID().String())
+
+	assert.Equal(t, "db.get_person_by_id", query.Name())
+	assert.Equal(t, request.SpanContext().SpanID(), query.Parent().SpanID())
+}
+
+func TestRequestIDAndStructuredLogs(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	var logs bytes.Buffer
+	previous := slog.Default()
+	logging.Setup(&logs, slog.LevelDebug)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	// A valid client ID is echoed, and tags every line of the request
+	w := httptest.NewRecorder()
+	req := makeAuthenticatedRequest("PUT", "/api/v2/person/1", []byte(`{"first_name": "John", "last_name": "Doe", "email": "john.doe@example.com"}`))
+	req.Header.Set(logging.RequestIDHeader, "client-id-1")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "client-id-1", w.Header().Get(logging.RequestIDHeader))
+
+	var lines []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(line, &entry), string(line))
+		lines = append(lines, entry)
+	}
+	require.NotEmpty(t, lines)
+	for _, entry := range lines {
+		assert.Equal(t, "client-id-1", entry["request_id"], entry["msg"])
+		assert.Equal(t, testAdminUser, entry["username"], entry["msg"])
+	}
+	access := lines[len(lines)-1]
+	assert.Equal(t, "request", access["msg"])
+	assert.Equal(t, "/api/v2/person/:id", access["route"])
+	assert.EqualValues(t, http.StatusOK, access["status"])
+
+	// Otherwise an ID is generated
+	for _, id := range []string{"", "has space", strings.Repeat("x", 200)} {
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", "/ping", nil)
+		req.Header.Set(logging.RequestIDHeader, id)
+		router.ServeHTTP(w, req)
+		assert.Regexp(t, "^[0-9a-f]{32}$", w.Header().Get(logging.RequestIDHeader))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	setupTestDatabase(t)
+
+	cfg := config.Default()
+	cfg.RateLimit.Routes = []string{"POST /auth/login=2/1m"}
+	cfg.RateLimit.Store = config.StoreSQLite
+	gin.SetMode(gin.TestMode)
+
+	login := func(router http.Handler) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username": "admin", "password": "wrong"}`)))
+		return w
+	}
+
+	router, err := setupRouter(cfg, nil)
+	require.NoError(t, err)
+	setupRoutes(router)
+
+	w := login(router)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+	assert.Equal(t, http.StatusUnauthorized, login(router).Code)
+
+	// Requests don't write to the database, buckets are saved by flushes
+	var saved int
+	require.NoError(t, database.DB.QueryRow("SELECT COUNT(*) FROM rate_limit_buckets").Scan(&saved))
+	assert.Zero(t, saved)
+	require.NoError(t, database.FlushRateLimits(context.Background()))
+
+	// Buckets persist in the database, as across a restart
+	router, err = setupRouter(cfg, nil)
+	require.NoError(t, err)
+	setupRoutes(router)
+
+	w = login(router)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Contains(t, mustJSON(t, w.Body.Bytes(), "error"), "Too many requests")
+
+	// Other routes fall back to the default limit, probes aren't limited
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "600", w.Header().Get("RateLimit-Limit"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	setupTestDatabase(t)
+	gin.SetMode(gin.TestMode)
+
+	cfg := config.Default()
+	cfg.RateLimit.Routes = []string{"POST /auth/login=2/1m"}
+	login := func(router http.Handler, forwardedFor string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username": "admin", "password": "wrong"}`))
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// No proxy is trusted by default, the header doesn't give a new bucket
+	router, err := setupRouter(cfg, nil)
+	require.NoError(t, err)
+	setupRoutes(router)
+	assert.Equal(t, http.StatusUnauthorized, login(router, "198.51.100.1"))
+	assert.Equal(t, http.StatusUnauthorized, login(router, "198.51.100.2"))
+	assert.Equal(t, http.StatusTooManyRequests, login(router, "198.51.100.3"))
+
+	// Behind a trusted proxy, the header identifies the client
+	cfg.Server.TrustedProxies = []string{"192.0.2.0/24"}
+	router, err = setupRouter(cfg, nil)
+	require.NoError(t, err)
+	setupRoutes(router)
+	assert.Equal(t, http.StatusUnauthorized, login(router, "198.51.100.1"))
+	assert.Equal(t, http.StatusUnauthorized, login(router, "198.51.100.1"))
+	assert.Equal(t, http.StatusTooManyRequests, login(router, "198.51.100.1"))
+	assert.Equal(t, http.StatusUnauthorized, login(router, "198.51.100.2"))
+}
+
+func TestSecurityHeadersAndCORS(t *testing.T) {
+	setupTestDatabase(t)
+
+	cfg := config.Default()
+	cfg.CORS.AllowedOrigins = []string{"https://app.example.com"}
+	gin.SetMode(gin.TestMode)
+	router, err := setupRouter(cfg, nil)
+	require.NoError(t, err)
+	setupRoutes(router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person/1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "default-src 'none'; frame-ancestors 'none'", w.Header().Get("Content-Security-Policy"))
+	assert.Empty(t, w.Header().Get("Strict-Transport-Security"), "HSTS is only sent over TLS")
+
+	// Preflight from an allowed origin
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("OPTIONS", "/api/v2/person/1", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	req.Header.Set("Access-Control-Request-Headers", "Authorization, Content-Type")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), "PUT")
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"), "preflights aren't rate limited")
+
+	// Actual request exposes the API headers
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/api/v1/person/1", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "Link")
+
+	// Other origins are refused
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/api/v2/person/1", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestClientCertificateAuth(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	// The TLS layer has verified the chain, its leaf names the user
+	verified := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "alice"}}}},
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("DELETE", "/api/v2/person/3", nil)
+	req.TLS = verified
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "max-age=31536000; includeSubDomains", w.Header().Get("Strict-Transport-Security"))
+
+	// Unverified client certificates don't authenticate
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("DELETE", "/api/v2/person/2", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: verified.VerifiedChains[0]}
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestCompressedResponsesAndBodies(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	// Export is above the threshold once there are enough persons
+	for i := range 20 {
+		createPersonDirect(t, router, fmt.Sprintf("bulk%d@example.com", i))
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v2/person.vcf", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, compression.Brotli, w.Header().Get("Content-Encoding"))
+	body, err := compression.NewReader(compression.Brotli, w.Body)
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Contains(t, string(decoded), "EMAIL:bulk19@example.com")
+
+	// zstd compressed request body
+	encoder, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	compressed := encoder.EncodeAll([]byte(`{"first_name": "Zed", "last_name": "Std", "email": "zed@example.com"}`), nil)
+	w = httptest.NewRecorder()
+	req = makeAuthenticatedRequest("POST", "/api/v2/person", compressed)
+	req.Header.Set("Content-Encoding", "zstd")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	w = httptest.NewRecorder()
+	req = makeAuthenticatedRequest("POST", "/api/v2/person", compressed)
+	req.Header.Set("Content-Encoding", "deflate")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestRequestBodyTooLarge(t *testing.T) {
+	setupTestDatabase(t)
+
+	cfg := config.Default()
+	cfg.Server.MaxBodyBytes = 256
+	gin.SetMode(gin.TestMode)
+	router, err := setupRouter(cfg, nil)
+	require.NoError(t, err)
+	setupRoutes(router)
+
+	large := []byte(`{"first_name": "` + strings.Repeat("x", 300) + `", "last_name": "Big", "email": "big@example.com"}`)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/api/v2/person", large))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// Without a Content-Length the limit hits while decoding
+	w = httptest.NewRecorder()
+	req := makeAuthenticatedRequest("PUT", "/api/v2/person/1", large)
+	req.ContentLength = -1
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// The limit applies to decompressed bodies, so small bombs are caught
+	encoder, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	req = makeAuthenticatedRequest("POST", "/api/v2/person.vcf", encoder.EncodeAll(bytes.Repeat([]byte("BEGIN:VCARD\r\n"), 100), nil))
+	req.Header.Set("Content-Type", "text/vcard")
+	req.Header.Set("Content-Encoding", "zstd")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+// createPersonDirect adds a person through the v2 API
+func createPersonDirect(t *testing.T, router http.Handler, email string) {
+	t.Helper()
+	body, err := json.Marshal(createTestPerson("Bulk", "Person", email))
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/api/v2/person", body))
+	require.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestEmbeddedSwaggerSpec(t *testing.T) {
+	router := setupTestRouter()
+
+	// Served from the binary, whatever the working directory
+	t.Chdir(t.TempDir())
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/docs/swagger.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `"2.0"`, mustJSON(t, w.Body.Bytes(), "swagger"))
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+}
+
+func TestAdminBackups(t *testing.T) {
+	setupTestDatabase(t)
+	cfg := config.Default()
+	cfg.Backup.Dir = t.TempDir()
+	gin.SetMode(gin.TestMode)
+	backups, err := newBackupManager(cfg.Backup)
+	require.NoError(t, err)
+	router, err := setupRouter(cfg, backups)
+	require.NoError(t, err)
+	setupRoutes(router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/admin/backups", nil))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created struct {
+		Data backup.Info `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.True(t, strings.HasSuffix(created.Data.Name, ".sqlite.gz"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("GET", "/admin/backups", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), created.Data.Name)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("DELETE", "/api/v2/person/1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Writes are rejected while the restore runs
+	restored := make(chan struct{})
+	sawMaintenance := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-restored:
+				sawMaintenance <- false
+				return
+			default:
+			}
+			if in, _ := database.InMaintenance(); in {
+				sawMaintenance <- true
+				return
+			}
+		}
+	}()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/admin/backups/"+created.Data.Name+"/restore", nil))
+	close(restored)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.True(t, <-sawMaintenance, "restored in maintenance mode")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "restored person")
+	in, _ := database.InMaintenance()
+	assert.False(t, in, "maintenance mode is left after the restore")
+
+	// Maintenance mode entered by an admin stays on after a restore
+	require.NoError(t, database.EnterMaintenance(context.Background(), time.Minute))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/admin/backups/"+created.Data.Name+"/restore", nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	in, _ = database.InMaintenance()
+	assert.True(t, in)
+	require.NoError(t, database.LeaveMaintenance(context.Background()))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/admin/backups/db_backup_2000-01-01_00-00-00.sqlite/restore", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Authenticated users other than the admin are turned away
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/admin/backups", nil)
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "alice"}}}},
+	}
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/backups", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Without a backup directory there are no backup routes
+	w = httptest.NewRecorder()
+	setupTestRouter().ServeHTTP(w, makeAuthenticatedRequest("GET", "/admin/backups", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminJobs(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	ctx := t.Context()
+	for _, job := range maintenanceJobs(config.Default().Maintenance, nil) {
+		require.NoError(t, maintenance.Run(ctx, job), job.Name)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("GET", "/admin/jobs", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var runs struct {
+		Data []models.JobRun `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &runs))
+	require.Len(t, runs.Data, 5)
+	assert.Equal(t, maintenance.JobPurgeDeleted, runs.Data[0].Job, "newest first")
+	assert.Equal(t, models.JobRunOK, runs.Data[0].Status)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("GET", "/admin/jobs?job=vacuum&limit=5", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &runs))
+	require.Len(t, runs.Data, 1)
+	assert.Equal(t, maintenance.JobVacuum, runs.Data[0].Job)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("GET", "/admin/jobs?limit=0", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/jobs", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+	t.Cleanup(func() { _ = database.LeaveMaintenance(context.Background()) })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/admin/maintenance", []byte(`{"retry_after": 120}`)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var status struct {
+		Data models.MaintenanceStatus `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.True(t, status.Data.Enabled)
+	assert.Equal(t, 120, status.Data.RetryAfter)
+
+	// Writes are rejected in every API version
+	person, _ := json.Marshal(createTestPerson("Read", "Only", "read.only@example.com"))
+	for _, req := range []*http.Request{
+		makeAuthenticatedRequest("POST", "/api/v1/person", person),
+		makeAuthenticatedRequest("POST", "/api/v2/person", person),
+		makeAuthenticatedRequest("PUT", "/api/v2/person/1", person),
+		makeAuthenticatedRequest("DELETE", "/api/v1/person/1", nil),
+	} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, req.Method+" "+req.URL.Path)
+		assert.Equal(t, "120", w.Header().Get("Retry-After"))
+	}
+
+	// Reads are served from the read-only connection
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err := database.DbAddPerson(t.Context(), createTestPerson("Not", "Blocked", "not.blocked@example.com"), "admin")
+	require.NoError(t, err, "the write pool stays open")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "read-only since")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("DELETE", "/admin/maintenance", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("DELETE", "/api/v2/person/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("GET", "/admin/maintenance", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.False(t, status.Data.Enabled)
+
+	// Without a body the configured Retry-After is sent
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/admin/maintenance", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("DELETE", "/api/v2/person/2", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("DELETE", "/api/v2/person/1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Gone from lookups and listings
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person/1", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("DELETE", "/api/v2/person/1", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "already deleted")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("PUT", "/api/v2/person/1", []byte(`{"first_name":"J","last_name":"D","email":"j.d@example.com"}`)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var list struct {
+		Data []models.Person `json:"data"`
+		Meta models.Meta     `json:"meta"`
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person", nil))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list.Data, 2)
+	assert.Equal(t, int64(2), list.Meta.Pagination.TotalItems)
+
+	// The admin view lists deleted persons along with their deletion time
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("GET", "/api/v2/person?include_deleted=true", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Data, 3)
+	require.NotNil(t, list.Data[0].DeletedAt)
+	assert.WithinDuration(t, time.Now(), *list.Data[0].DeletedAt, time.Minute)
+	assert.Nil(t, list.Data[1].DeletedAt)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("GET", "/api/v2/person/1?include_deleted=true", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person?include_deleted=true", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v2/person?include_deleted=true", nil)
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "alice"}}}},
+	}
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// The email is free for someone else, until the person is restored
+	other, _ := json.Marshal(createTestPerson("John", "Other", "john.doe@example.com"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/api/v2/person", other))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/api/v1/person/1/restore", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("DELETE", "/api/v2/person/4", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/api/v1/person/1/restore", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Header().Values("Link"), `</api/v1/person/1>; rel="self"`)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/api/v1/person/1/restore", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "not deleted")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Purging only removes persons deleted before the retention period
+	purge := maintenance.PurgeDeleted(time.Hour)
+	detail, err := purge(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "0 deleted persons purged", detail)
+	detail, err = maintenance.PurgeDeleted(0)(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "1 deleted persons purged", detail)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/api/v1/person/4/restore", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPersonHistory(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	var history struct {
+		Data []models.PersonRevision `json:"data"`
+	}
+	getHistory := func(url string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, makeAuthenticatedRequest("GET", url, nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	}
+
+	before := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	update, _ := json.Marshal(createTestPerson("Johnny", "Doe", "johnny.doe@example.com"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("PUT", "/api/v2/person/1", update))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("DELETE", "/api/v2/person/1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Deleted persons' history is only for admins
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person/1/history", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person/1/history?include_deleted=true", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v2/person/1/history?include_deleted=true", nil)
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "alice"}}}},
+	}
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	getHistory("/api/v2/person/1/history?include_deleted=true")
+	require.Len(t, history.Data, 3)
+	assert.Equal(t, []string{"insert", "update", "delete"},
+		[]string{history.Data[0].Operation, history.Data[1].Operation, history.Data[2].Operation})
+	assert.Equal(t, "john.doe@example.com", history.Data[0].Email)
+	assert.Equal(t, "johnny.doe@example.com", history.Data[1].Email)
+	assert.NotNil(t, history.Data[2].DeletedAt)
+	first := history.Data[0].Revision
+
+	// as_of sees the person as it was
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("GET", "/api/v2/person/1?include_deleted=true&fields=email&as_of="+before.Format(time.RFC3339Nano), nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"email":"john.doe@example.com"}`, mustJSON(t, w.Body.Bytes(), "data"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("GET", "/api/v2/person/1?include_deleted=true&as_of="+time.Now().Format(time.RFC3339Nano), nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "deleted by then")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person/2?as_of=2000-01-01T00:00:00Z", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "not created yet")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person/2?as_of=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Failing history lookups are errors, not missing persons
+	_, err := database.DB.Exec("ALTER TABLE people_history RENAME TO people_history_gone")
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person/2?as_of="+time.Now().Format(time.RFC3339Nano), nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	// v1 reports the failure too, in its own body
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/person/2?as_of="+time.Now().Format(time.RFC3339Nano), nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"error": "Failed to retrieve person"}`, w.Body.String())
+	_, err = database.DB.Exec("ALTER TABLE people_history_gone RENAME TO people_history")
+	require.NoError(t, err)
+
+	// Reverting restores the first revision, and is recorded too
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", fmt.Sprintf("/api/v1/person/1/history/%d/revert", first), nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Header().Values("Link"), `</api/v1/person/1>; rel="self"`)
+	assert.Contains(t, w.Body.String(), "john.doe@example.com")
+	getHistory("/api/v2/person/1/history")
+	require.Len(t, history.Data, 4)
+	assert.Equal(t, "restore", history.Data[3].Operation)
+	assert.Equal(t, "John", history.Data[3].FirstName)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", fmt.Sprintf("/api/v1/person/2/history/%d/revert", first), nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "revision of another person")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", "/api/v1/person/1/history/x/revert", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", fmt.Sprintf("/api/v1/person/1/history/%d/revert", first), nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Reverting to an email taken meanwhile conflicts
+	update, _ = json.Marshal(createTestPerson("John", "Doe", "john.d@example.com"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("PUT", "/api/v2/person/1", update))
+	require.Equal(t, http.StatusOK, w.Code)
+	update, _ = json.Marshal(createTestPerson("Jane", "Smith", "john.doe@example.com"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("PUT", "/api/v2/person/2", update))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("POST", fmt.Sprintf("/api/v2/person/1/history/%d/revert", first), nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Purging a person removes its history
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("DELETE", "/api/v2/person/1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	_, err = maintenance.PurgeDeleted(0)(t.Context())
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("GET", "/api/v2/person/1/history?include_deleted=true", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPersonMetadata(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	var person struct {
+		Data models.Person `json:"data"`
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person/1", nil))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &person))
+	created := person.Data.CreatedAt
+	assert.WithinDuration(t, time.Now(), created, time.Minute)
+	assert.Equal(t, created, person.Data.UpdatedAt)
+	assert.Empty(t, person.Data.CreatedBy, "sample data")
+
+	// Changes are attributed to the authenticated user, whatever the body says
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("PUT", "/api/v2/person/1",
+		strings.NewReader(`{"first_name":"John","last_name":"Doe","email":"john.doe@example.com","created_by":"mallory","updated_by":"mallory"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "alice"}}}},
+	}
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person/1", nil))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &person))
+	assert.Equal(t, created, person.Data.CreatedAt)
+	assert.Empty(t, person.Data.CreatedBy)
+	assert.True(t, person.Data.UpdatedAt.After(since))
+	assert.Equal(t, "alice", person.Data.UpdatedBy)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person/1/history", nil))
+	assert.Contains(t, w.Body.String(), `"changed_by":"alice"`)
+
+	// Listing only the persons changed since
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("DELETE", "/api/v2/person/2", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var list struct {
+		Data []models.Person `json:"data"`
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person?fields=updated_by&updated_since="+since.Format(time.RFC3339Nano), nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Data, 1)
+	assert.Equal(t, "alice", list.Data[0].UpdatedBy)
+	assert.Contains(t, w.Body.String(), "updated_since=", "kept in the pagination links")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("GET", "/api/v2/person?include_deleted=true&updated_since="+since.Format(time.RFC3339Nano), nil))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Data, 2, "with the delete")
+	assert.Equal(t, "admin", list.Data[1].UpdatedBy)
+	assert.NotNil(t, list.Data[1].DeletedAt)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person?updated_since="+time.Now().Add(time.Hour).Format(time.RFC3339), nil))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Empty(t, list.Data)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/person?updated_since=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPersonChanges(t *testing.T) {
+	setupTestDatabase(t)
+	router := setupTestRouter()
+
+	var feed struct {
+		Data  []models.PersonChange `json:"data"`
+		Links models.Links          `json:"links"`
+	}
+	getChanges := func(url string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, makeAuthenticatedRequest("GET", url, nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		feed.Data, feed.Links = nil, models.Links{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &feed))
+	}
+
+	// The feed starts with every person
+	getChanges("/api/v2/changes?limit=2")
+	require.Len(t, feed.Data, 2)
+	assert.Equal(t, "insert", feed.Data[0].Operation)
+	assert.Equal(t, "john.doe@example.com", feed.Data[0].Person.Email)
+	assert.Equal(t, "/api/v2/changes?limit=2&since=2", feed.Links.Next)
+	getChanges(feed.Links.Next)
+	require.Len(t, feed.Data, 1)
+	assert.Equal(t, uint64(3), feed.Data[0].PersonID)
+	getChanges(feed.Links.Next)
+	assert.Empty(t, feed.Data)
+	assert.Equal(t, "/api/v2/changes?limit=2&since=3", feed.Links.Next, "stays put when caught up")
+
+	// v1 sends the next link as a header only
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("GET", "/api/v1/changes?limit=2", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, strings.Join(w.Header().Values("Link"), ", "), `</api/v1/changes?limit=2&since=2>; rel="next"`)
+
+	// Writes, and only those that commit, show up in order
+	person, _ := json.Marshal(createTestPerson("Alice", "Wonder", "alice.wonder@example.com"))
+	duplicate, _ := json.Marshal(createTestPerson("John", "Again", "john.doe@example.com"))
+	update, _ := json.Marshal(createTestPerson("Alice", "Liddell", "alice.wonder@example.com"))
+	for _, write := range []struct {
+		method, url string
+		body        []byte
+		status      int
+	}{
+		{"POST", "/api/v2/person", person, http.StatusCreated},
+		{"POST", "/api/v2/person", duplicate, http.StatusConflict},
+		{"PUT", "/api/v2/person/4", update, http.StatusOK},
+		{"DELETE", "/api/v2/person/4", nil, http.StatusOK},
+		{"POST", "/api/v2/person/4/restore", nil, http.StatusOK},
+		{"DELETE", "/api/v2/person/2", nil, http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, makeAuthenticatedRequest(write.method, write.url, write.body))
+		require.Equal(t, write.status, w.Code, w.Body.String())
+	}
+	_, err := maintenance.PurgeDeleted(0)(t.Context())
+	require.NoError(t, err)
+
+	getChanges("/api/v2/changes?since=3")
+	require.Len(t, feed.Data, 5, "purging a deleted person adds nothing")
+	var operations []string
+	for i, change := range feed.Data {
+		assert.Equal(t, int64(4+i), change.Seq)
+		operations = append(operations, change.Operation)
+	}
+	assert.Equal(t, []string{"insert", "update", "delete", "insert", "delete"}, operations)
+	assert.Equal(t, "Liddell", feed.Data[1].Person.LastName)
+	assert.Equal(t, "admin", feed.Data[1].Person.UpdatedBy)
+	assert.Equal(t, feed.Data[0].Person.CreatedAt, feed.Data[1].Person.CreatedAt)
+	assert.Nil(t, feed.Data[2].Person, "tombstone")
+	assert.Equal(t, "admin", feed.Data[2].ChangedBy)
+	assert.Equal(t, uint64(2), feed.Data[4].PersonID)
+	assert.Nil(t, feed.Data[4].Person)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/changes", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, makeAuthenticatedRequest("GET", "/api/v1/changes?since=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// toggleMaintenanceOnSignal does nothing, as there is no SIGUSR1 on this
+// platform; use the admin endpoint instead
+func toggleMaintenanceOnSignal(ctx context.Context, retryAfter time.Duration) {}
@@ -0,0 +1,45 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/database"
+)
+
+// toggleMaintenanceOnSignal enters maintenance mode on SIGUSR1, or leaves it
+// when already in it, until ctx is done. The signal is handled once it
+// returns, the toggling happens in the background.
+func toggleMaintenanceOnSignal(ctx context.Context, retryAfter time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go toggleMaintenance(ctx, signals, retryAfter)
+}
+
+// toggleMaintenance toggles maintenance mode on every signal
+func toggleMaintenance(ctx context.Context, signals chan os.Signal, retryAfter time.Duration) {
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			var err error
+			if on, _ := database.InMaintenance(); on {
+				err = database.LeaveMaintenance(ctx)
+			} else {
+				err = database.EnterMaintenance(ctx, retryAfter)
+			}
+			if err != nil {
+				slog.ErrorContext(ctx, "Toggling maintenance mode failed", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToggleMaintenanceOnSignal(t *testing.T) {
+	setupTestDatabase(t)
+	t.Cleanup(func() { _ = database.LeaveMaintenance(context.Background()) })
+	toggleMaintenanceOnSignal(t.Context(), time.Minute)
+
+	inMaintenance := func() bool {
+		on, _ := database.InMaintenance()
+		return on
+	}
+
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR1))
+	require.Eventually(t, inMaintenance, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR1))
+	require.Eventually(t, func() bool { return !inMaintenance() }, 5*time.Second, 10*time.Millisecond)
+}
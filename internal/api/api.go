@@ -1,33 +1,40 @@
 package api
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/atrakic/gin-sqlite/internal/auth"
 	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/atrakic/gin-sqlite/internal/metrics"
 	"github.com/atrakic/gin-sqlite/internal/models"
 	"github.com/gin-gonic/gin"
 )
 
 // Login authenticates a user and returns a JWT token
 // @Summary User login
-// @Description Authenticate user credentials and return JWT token
+// @Description Authenticate user credentials and return JWT token.
+// @Description /auth/login is the deprecated v1 login, answering with a bare LoginResponse and v1 error bodies.
 // @Tags auth
 // @Accept json
-// @Produce json
+// @Produce json,xml,application/x-yaml,application/x-msgpack
 // @Param credentials body models.LoginRequest true "Login credentials"
-// @Success 200 {object} models.LoginResponse "Login successful"
+// @Success 200 {object} models.APIResponse{data=models.LoginResponse} "Login successful"
 // @Failure 400 {object} models.APIResponse "Invalid request"
 // @Failure 401 {object} models.APIResponse "Invalid credentials"
+// @Failure 406 {object} models.APIResponse "Not acceptable"
+// @Router /api/v2/auth/login [post]
 // @Router /auth/login [post]
 func Login(c *gin.Context) {
 	var loginRequest models.LoginRequest
 
 	if err := c.ShouldBindJSON(&loginRequest); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
+		Render(c, http.StatusBadRequest, models.APIResponse{
 			Error: "Invalid request format",
 		})
 		return
@@ -35,7 +42,8 @@ func Login(c *gin.Context) {
 
 	// Validate credentials
 	if !auth.ValidateCredentials(loginRequest.Username, loginRequest.Password) {
-		c.JSON(http.StatusUnauthorized, models.APIResponse{
+		metrics.Logins.WithLabelValues("failure").Inc()
+		Render(c, http.StatusUnauthorized, models.APIResponse{
 			Error: "Invalid username or password",
 		})
 		return
@@ -44,16 +52,22 @@ func Login(c *gin.Context) {
 	// Generate JWT token
 	token, expiresAt, err := auth.GenerateJWT(loginRequest.Username)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		Render(c, http.StatusInternalServerError, models.APIResponse{
 			Error: "Failed to generate token",
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, models.LoginResponse{
+	metrics.Logins.WithLabelValues("success").Inc()
+	response := models.LoginResponse{
 		Token:     token,
 		ExpiresAt: expiresAt,
-	})
+	}
+	if v1Bodies(c) {
+		renderBody(c, http.StatusOK, response)
+		return
+	}
+	Render(c, http.StatusOK, models.APIResponse{Data: response})
 }
 
 // GetPersons retrieves persons from the database with pagination
@@ -61,13 +75,21 @@ func Login(c *gin.Context) {
 // @Description Get a paginated list of all persons in the database
 // @Tags persons
 // @Accept json
-// @Produce json
+// @Produce json,xml,application/x-yaml,application/x-msgpack
 // @Param page query int false "Page number (default: 1)" minimum(1) example(1)
 // @Param page_size query int false "Number of items per page (default: 10, max: 100)" minimum(1) maximum(100) example(10)
-// @Success 200 {object} models.PaginatedResponse "Paginated list of persons"
-// @Failure 400 {object} models.APIResponse "Invalid pagination parameters"
+// @Param fields query string false "Comma separated list of fields to return (default: all)" example(id,first_name)
+// @Param include query string false "Comma separated list of related resources to include"
+// @Param include_deleted query bool false "Also list deleted persons, admin only"
+// @Param updated_since query string false "Only list persons changed at or after this RFC 3339 time, for incremental sync; deletes only show with include_deleted" format(date-time)
+// @Success 200 {object} models.APIResponse{data=[]models.Person} "Paginated list of persons, with links also sent as a Link header"
+// @Failure 400 {object} models.APIResponse "Invalid pagination, fields, include or updated_since parameters"
+// @Failure 401 {object} models.APIResponse "Not authenticated, with include_deleted"
+// @Failure 403 {object} models.APIResponse "Not an admin, with include_deleted"
+// @Failure 406 {object} models.APIResponse "Not acceptable"
 // @Failure 500 {object} models.APIResponse "Internal server error"
 // @Router /api/v1/person [get]
+// @Router /api/v2/person [get]
 func GetPersons(c *gin.Context) {
 	// Parse pagination parameters
 	var pagination models.PaginationRequest
@@ -78,7 +100,7 @@ func GetPersons(c *gin.Context) {
 
 	// Bind query parameters
 	if err := c.ShouldBindQuery(&pagination); err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
+		Render(c, http.StatusBadRequest, models.APIResponse{
 			Error: "Invalid pagination parameters: " + err.Error(),
 		})
 		return
@@ -95,29 +117,62 @@ func GetPersons(c *gin.Context) {
 		pagination.PageSize = 100
 	}
 
+	fields, err := parseFields(c)
+	if err != nil {
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: err.Error()})
+		return
+	}
+
+	includes, err := parseIncludes(c)
+	if err != nil {
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: err.Error()})
+		return
+	}
+
+	var filter database.PersonFilter
+	if filter.IncludeDeleted, err = includeDeleted(c); err != nil {
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: err.Error()})
+		return
+	}
+	if since := c.Query("updated_since"); since != "" {
+		if filter.UpdatedSince, err = time.Parse(time.RFC3339Nano, since); err != nil {
+			Render(c, http.StatusBadRequest, models.APIResponse{Error: "updated_since must be an RFC 3339 time"})
+			return
+		}
+	}
+
 	// Calculate offset
 	offset := (pagination.Page - 1) * pagination.PageSize
 
 	// Get total count
-	totalCount, err := database.DbGetPersonsCount()
+	totalCount, err := database.DbGetPersonsCount(c.Request.Context(), filter)
 	if err != nil {
-		log.Printf("Database error getting count: %v", err)
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		slog.ErrorContext(c.Request.Context(), "Database error getting count", "error", err)
+		Render(c, http.StatusInternalServerError, models.APIResponse{
 			Error: "Failed to get total count",
 		})
 		return
 	}
 
 	// Get persons with pagination
-	persons, err := database.DbGetPersons(pagination.PageSize, offset)
+	persons, err := database.DbGetPersons(c.Request.Context(), filter, pagination.PageSize, offset, fields)
 	if err != nil {
-		log.Printf("Database error getting persons: %v", err)
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		slog.ErrorContext(c.Request.Context(), "Database error getting persons", "error", err)
+		Render(c, http.StatusInternalServerError, models.APIResponse{
 			Error: "Failed to retrieve persons",
 		})
 		return
 	}
 
+	included, err := loadIncludes(c, includes, persons)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error loading includes", "error", err)
+		Render(c, http.StatusInternalServerError, models.APIResponse{
+			Error: "Failed to retrieve included resources",
+		})
+		return
+	}
+
 	// Calculate pagination metadata
 	totalPages := int((totalCount + int64(pagination.PageSize) - 1) / int64(pagination.PageSize))
 	if totalPages == 0 {
@@ -134,122 +189,343 @@ func GetPersons(c *gin.Context) {
 	}
 
 	// Return paginated response
-	response := models.PaginatedResponse{
-		Data:       persons,
-		Pagination: paginationMeta,
-	}
-
-	c.JSON(http.StatusOK, response)
+	Render(c, http.StatusOK, models.APIResponse{
+		Data:     projectPersons(persons, fields),
+		Meta:     models.Meta{Pagination: &paginationMeta},
+		Links:    paginationLinks(c, paginationMeta),
+		Included: included,
+	})
 }
 
 // GetPersonByID retrieves a person by their ID
 // @Summary Get person by ID
-// @Description Get a single person by their ID
+// @Description Get a single person by their ID. Append .vcf to the ID to get the person as a vCard.
 // @Tags persons
 // @Accept json
-// @Produce json
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Produce text/vcard
 // @Param id path int true "Person ID"
-// @Success 200 {object} models.Person "Person details"
+// @Param fields query string false "Comma separated list of fields to return (default: all)" example(id,first_name)
+// @Param include query string false "Comma separated list of related resources to include"
+// @Param include_deleted query bool false "Also find a deleted person, admin only"
+// @Param as_of query string false "Return the person as it was at this RFC 3339 time" format(date-time)
+// @Success 200 {object} models.APIResponse{data=models.Person} "Person details"
+// @Failure 400 {object} models.APIResponse "Invalid fields, include or as_of parameters"
+// @Failure 401 {object} models.APIResponse "Not authenticated, with include_deleted"
+// @Failure 403 {object} models.APIResponse "Not an admin, with include_deleted"
 // @Failure 404 {object} models.APIResponse "Person not found"
+// @Failure 406 {object} models.APIResponse "Not acceptable"
+// @Failure 500 {object} models.APIResponse "Internal server error"
 // @Router /api/v1/person/{id} [get]
+// @Router /api/v2/person/{id} [get]
 func GetPersonByID(c *gin.Context) {
 	id := c.Param("id")
 
-	person, err := database.DbGetPersonByID(id)
-	checkErr(err)
+	// gin cannot route /person/:id.vcf separately from /person/:id
+	if vcfID, ok := strings.CutSuffix(id, ".vcf"); ok {
+		GetPersonVCard(c, vcfID)
+		return
+	}
 
-	if person.FirstName == "" {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No Records Found"})
+	fields, err := parseFields(c)
+	if err != nil {
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"data": person})
+
+	includes, err := parseIncludes(c)
+	if err != nil {
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: err.Error()})
+		return
+	}
+
+	deleted, err := includeDeleted(c)
+	if err != nil {
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: err.Error()})
+		return
+	}
+
+	var person models.Person
+	if asOf := c.Query("as_of"); asOf != "" {
+		var at time.Time
+		at, err = time.Parse(time.RFC3339Nano, asOf)
+		personID, idErr := strconv.Atoi(id)
+		if err != nil || idErr != nil {
+			Render(c, http.StatusBadRequest, models.APIResponse{Error: "as_of must be an RFC 3339 time and the ID a number"})
+			return
+		}
+		person, err = database.DbGetPersonAsOf(c.Request.Context(), personID, at, deleted)
+		checkErr(c, err)
+	} else {
+		person, err = database.DbGetPersonByID(c.Request.Context(), id, fields, deleted)
+		checkErr(c, err)
+	}
+
+	if err != nil {
+		Render(c, http.StatusInternalServerError, models.APIResponse{Error: "Failed to retrieve person"})
+		return
+	}
+
+	if person.ID == 0 {
+		Render(c, http.StatusNotFound, models.APIResponse{Error: "No Records Found"})
+		return
+	}
+
+	included, err := loadIncludes(c, includes, []models.Person{person})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error loading includes", "error", err)
+		Render(c, http.StatusInternalServerError, models.APIResponse{
+			Error: "Failed to retrieve included resources",
+		})
+		return
+	}
+
+	var data interface{} = person
+	if len(fields) > 0 {
+		data = person.Project(fields)
+	}
+
+	Render(c, http.StatusOK, models.APIResponse{
+		Data:     data,
+		Links:    selfLinks(c),
+		Included: included,
+	})
 }
 
 // AddPerson creates a new person
 // @Summary Create a new person
-// @Description Create a new person with the provided information
+// @Description Create a new person with the provided information.
+// @Description v2 answers 201 with a Location header and reports failures, v1 always answers 200.
 // @Tags persons
-// @Accept json
-// @Produce json
+// @Accept json,xml,application/x-yaml,application/x-msgpack
+// @Produce json,xml,application/x-yaml,application/x-msgpack
 // @Param person body models.CreatePersonRequest true "Person to create"
-// @Success 200 {object} models.APIResponse "Success message"
+// @Success 200 {object} models.APIResponse{data=models.Person} "Created person (v1)"
+// @Success 201 {object} models.APIResponse{data=models.Person} "Created person (v2)"
 // @Failure 400 {object} models.APIResponse "Invalid input"
+// @Failure 406 {object} models.APIResponse "Not acceptable"
+// @Failure 409 {object} models.APIResponse "Email already in use (v2 only)"
+// @Failure 413 {object} models.APIResponse "Request body too large"
+// @Failure 415 {object} models.APIResponse "Unsupported Content-Type or Content-Encoding"
+// @Failure 500 {object} models.APIResponse "Internal server error (v2 only)"
+// @Failure 503 {object} models.APIResponse "Read-only maintenance in progress, with a Retry-After header"
 // @Security BearerAuth
 // @Router /api/v1/person [post]
+// @Router /api/v2/person [post]
 func AddPerson(c *gin.Context) {
 	var json models.Person
 
-	if err := c.ShouldBindJSON(&json); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindBody(c, &json); err != nil {
+		renderBindError(c, err)
 		return
 	}
 
-	_, err := database.DbAddPerson(json)
-	checkErr(err)
+	person, err := database.DbAddPerson(c.Request.Context(), json, username(c))
+	checkErr(c, err)
 
-	c.JSON(http.StatusOK, gin.H{"message": "Person added successfully"})
+	if apiVersion(c) == V1 {
+		response := models.APIResponse{Message: "Person added successfully"}
+		if err == nil {
+			response.Data = person
+			response.Links = resourceLinks(personPath(c, person.ID))
+		}
+		Render(c, http.StatusOK, response)
+		return
+	}
+
+	if err != nil {
+		renderWriteError(c, err)
+		return
+	}
+
+	location := personPath(c, person.ID)
+	c.Header("Location", location)
+	Render(c, http.StatusCreated, models.APIResponse{
+		Data:    person,
+		Links:   resourceLinks(location),
+		Message: "Person added successfully",
+	})
 }
 
 // UpdatePerson updates an existing person
 // @Summary Update a person
-// @Description Update an existing person by ID
+// @Description Update an existing person by ID.
+// @Description v2 answers 404 for unknown IDs and 409 for emails already in use, v1 answers 200 and 400.
 // @Tags persons
-// @Accept json
-// @Produce json
+// @Accept json,xml,application/x-yaml,application/x-msgpack
+// @Produce json,xml,application/x-yaml,application/x-msgpack
 // @Param id path int true "Person ID"
 // @Param person body models.UpdatePersonRequest true "Person to update"
 // @Success 200 {object} models.APIResponse "Success message"
 // @Failure 400 {object} models.APIResponse "Invalid input or ID"
+// @Failure 404 {object} models.APIResponse "Person not found (v2 only)"
+// @Failure 406 {object} models.APIResponse "Not acceptable"
+// @Failure 409 {object} models.APIResponse "Email already in use (v2 only)"
+// @Failure 413 {object} models.APIResponse "Request body too large"
+// @Failure 415 {object} models.APIResponse "Unsupported Content-Type or Content-Encoding"
+// @Failure 500 {object} models.APIResponse "Internal server error (v2 only)"
+// @Failure 503 {object} models.APIResponse "Read-only maintenance in progress, with a Retry-After header"
 // @Security BearerAuth
 // @Router /api/v1/person/{id} [put]
+// @Router /api/v2/person/{id} [put]
 func UpdatePerson(c *gin.Context) {
 	personID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: "Invalid ID"})
+		return
 	}
 
 	var json models.Person
-	if err := c.ShouldBindJSON(&json); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindBody(c, &json); err != nil {
+		renderBindError(c, err)
 		return
 	}
 
-	if _, err := database.DbUpdatePerson(json, personID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err})
+	slog.DebugContext(c.Request.Context(), "Updating person", "id", personID)
+	updated, err := database.DbUpdatePerson(c.Request.Context(), json, personID, username(c))
+	if err != nil {
+		if apiVersion(c) == V1 {
+			Render(c, http.StatusBadRequest, models.APIResponse{Error: err.Error()})
+			return
+		}
+		renderWriteError(c, err)
+		return
+	}
+
+	if !updated && apiVersion(c) != V1 {
+		Render(c, http.StatusNotFound, models.APIResponse{Error: "No Records Found"})
+		return
 	}
 
-	fmt.Printf("Updating id %d", personID)
-	c.JSON(http.StatusOK, gin.H{"message": "Success"})
+	Render(c, http.StatusOK, models.APIResponse{
+		Message: "Success",
+		Links:   selfLinks(c),
+	})
 }
 
-// DeletePerson deletes a person by ID
+// DeletePerson soft deletes a person by ID
 // @Summary Delete a person
-// @Description Delete a person by ID
+// @Description Delete a person by ID. The person can be restored until it is purged after the retention period.
+// @Description v2 answers 404 for unknown IDs, v1 answers 200.
 // @Tags persons
 // @Accept json
-// @Produce json
+// @Produce json,xml,application/x-yaml,application/x-msgpack
 // @Param id path int true "Person ID"
 // @Success 200 {object} models.APIResponse "Success message"
 // @Failure 400 {object} models.APIResponse "Invalid ID"
+// @Failure 404 {object} models.APIResponse "Person not found (v2 only)"
+// @Failure 406 {object} models.APIResponse "Not acceptable"
 // @Failure 500 {object} models.APIResponse "Internal server error"
+// @Failure 503 {object} models.APIResponse "Read-only maintenance in progress, with a Retry-After header"
 // @Security BearerAuth
 // @Router /api/v1/person/{id} [delete]
+// @Router /api/v2/person/{id} [delete]
 func DeletePerson(c *gin.Context) {
 	personID, err := strconv.Atoi(c.Params.ByName("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: "Invalid ID"})
+		return
+	}
+
+	deleted, err := database.DbDeletePerson(c.Request.Context(), personID, username(c))
+	if err != nil {
+		checkErr(c, err)
+		Render(c, http.StatusInternalServerError, models.APIResponse{Error: "Failed to delete person"})
+		return
+	}
+
+	if !deleted && apiVersion(c) != V1 {
+		Render(c, http.StatusNotFound, models.APIResponse{Error: "No Records Found"})
+		return
+	}
+
+	Render(c, http.StatusOK, models.APIResponse{Message: "id #" + strconv.Itoa(personID) + " deleted"})
+}
+
+// RestorePerson undoes the deletion of a person
+// @Summary Restore a deleted person
+// @Description Restore a person deleted within the retention period, after which deleted persons are purged
+// @Tags persons
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Param id path int true "Person ID"
+// @Success 200 {object} models.APIResponse{data=models.Person} "Restored person"
+// @Failure 400 {object} models.APIResponse "Invalid ID"
+// @Failure 404 {object} models.APIResponse "No deleted person with the ID"
+// @Failure 406 {object} models.APIResponse "Not acceptable"
+// @Failure 409 {object} models.APIResponse "Email taken by another person meanwhile"
+// @Failure 500 {object} models.APIResponse "Internal server error"
+// @Failure 503 {object} models.APIResponse "Read-only maintenance in progress, with a Retry-After header"
+// @Security BearerAuth
+// @Router /api/v1/person/{id}/restore [post]
+// @Router /api/v2/person/{id}/restore [post]
+func RestorePerson(c *gin.Context) {
+	personID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: "Invalid ID"})
+		return
+	}
+
+	restored, err := database.DbRestorePerson(c.Request.Context(), personID, username(c))
+	if err != nil {
+		checkErr(c, err)
+		renderWriteError(c, err)
+		return
+	}
+	if !restored {
+		Render(c, http.StatusNotFound, models.APIResponse{Error: "No deleted person with this ID"})
+		return
+	}
+
+	person, err := database.DbGetPersonByID(c.Request.Context(), c.Param("id"), nil, false)
+	if err != nil {
+		checkErr(c, err)
+		Render(c, http.StatusInternalServerError, models.APIResponse{Error: "Failed to retrieve person"})
+		return
+	}
+	Render(c, http.StatusOK, models.APIResponse{
+		Data:    person,
+		Links:   resourceLinks(strings.TrimSuffix(c.Request.URL.Path, "/restore")),
+		Message: "id #" + strconv.Itoa(personID) + " restored",
+	})
+}
+
+// includeDeleted parses ?include_deleted=, which the router only lets
+// admins set
+func includeDeleted(c *gin.Context) (bool, error) {
+	value := c.Query("include_deleted")
+	if value == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("include_deleted must be true or false, got %q", value)
 	}
+	return include, nil
+}
 
-	if _, err := database.DbDeletePerson(personID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+// renderWriteError answers a failed write with 409 for duplicates and 500 otherwise
+func renderWriteError(c *gin.Context, err error) {
+	if errors.Is(err, database.ErrDuplicate) {
+		Render(c, http.StatusConflict, models.APIResponse{Error: "Email already in use"})
+		return
 	}
+	Render(c, http.StatusInternalServerError, models.APIResponse{Error: "Failed to save person"})
+}
+
+// username returns the authenticated user making the request, recorded as
+// the author of its writes
+func username(c *gin.Context) string {
+	return c.GetString("username")
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "id #" + strconv.Itoa(personID) + " deleted"})
+// personPath returns the path of a person below the current route group
+func personPath(c *gin.Context, id uint64) string {
+	return strings.TrimSuffix(c.FullPath(), "/") + "/" + strconv.FormatUint(id, 10)
 }
 
-// checkErr is ...
-func checkErr(err error) {
+// checkErr logs a database error with the request attributes
+func checkErr(c *gin.Context, err error) {
 	if err != nil {
-		log.Printf("Database error: %v", err)
+		slog.ErrorContext(c.Request.Context(), "Database error", "error", err)
 	}
 }
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/backup"
+	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// ListBackups returns a handler listing the backups kept by m
+// @Summary List database backups
+// @Description List the database backups, newest first
+// @Tags admin
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Success 200 {object} models.APIResponse{data=[]backup.Info} "Backups"
+// @Failure 401 {object} models.APIResponse "Not authenticated"
+// @Failure 403 {object} models.APIResponse "Not an admin"
+// @Failure 500 {object} models.APIResponse "Internal server error"
+// @Security BearerAuth
+// @Router /admin/backups [get]
+func ListBackups(m *backup.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		backups, err := m.List(c.Request.Context())
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Listing backups failed", "error", err)
+			Render(c, http.StatusInternalServerError, models.APIResponse{Error: "Failed to list backups"})
+			return
+		}
+		Render(c, http.StatusOK, models.APIResponse{Data: backups})
+	}
+}
+
+// CreateBackup returns a handler taking a backup with m
+// @Summary Back up the database
+// @Description Take an online, integrity checked and compressed backup of the database, then prune the backups outside the retention policy
+// @Tags admin
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Success 201 {object} models.APIResponse{data=backup.Info} "Backup created"
+// @Failure 401 {object} models.APIResponse "Not authenticated"
+// @Failure 403 {object} models.APIResponse "Not an admin"
+// @Failure 500 {object} models.APIResponse "Internal server error"
+// @Security BearerAuth
+// @Router /admin/backups [post]
+func CreateBackup(m *backup.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		info, err := m.Create(c.Request.Context())
+		if err != nil && info.Name == "" {
+			slog.ErrorContext(c.Request.Context(), "Backup failed", "error", err)
+			Render(c, http.StatusInternalServerError, models.APIResponse{Error: "Failed to create backup"})
+			return
+		}
+		if err != nil {
+			// The backup itself succeeded
+			slog.WarnContext(c.Request.Context(), "Backup created but not pruned", "error", err)
+		}
+		Render(c, http.StatusCreated, models.APIResponse{Data: info, Message: "Backup created"})
+	}
+}
+
+// RestoreBackup returns a handler restoring one of the backups kept by m,
+// in maintenance mode so that no write lands while the database is replaced.
+// Clients are told to retry writes after retryAfter.
+// @Summary Restore a database backup
+// @Description Replace the contents of the database with a backup, after checking its integrity. Open connections see the restored data right away.
+// @Description The database is in maintenance mode during the restore, entering it for the restore only unless it was already on.
+// @Tags admin
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Param name path string true "Backup name"
+// @Success 200 {object} models.APIResponse "Backup restored"
+// @Failure 401 {object} models.APIResponse "Not authenticated"
+// @Failure 403 {object} models.APIResponse "Not an admin"
+// @Failure 404 {object} models.APIResponse "Backup not found"
+// @Failure 500 {object} models.APIResponse "Internal server error"
+// @Security BearerAuth
+// @Router /admin/backups/{name}/restore [post]
+func RestoreBackup(m *backup.Manager, retryAfter time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if in, _ := database.InMaintenance(); !in {
+			if err := database.EnterMaintenance(ctx, retryAfter); err != nil {
+				slog.ErrorContext(ctx, "Entering maintenance mode failed", "error", err)
+				Render(c, http.StatusInternalServerError, models.APIResponse{Error: "Failed to enter maintenance mode"})
+				return
+			}
+			defer func() {
+				if err := database.LeaveMaintenance(context.WithoutCancel(ctx)); err != nil {
+					slog.ErrorContext(ctx, "Leaving maintenance mode failed", "error", err)
+				}
+			}()
+		}
+
+		err := m.Restore(ctx, c.Param("name"))
+		if errors.Is(err, backup.ErrNotFound) {
+			Render(c, http.StatusNotFound, models.APIResponse{Error: "Backup not found"})
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Restore failed", "error", err)
+			Render(c, http.StatusInternalServerError, models.APIResponse{Error: "Failed to restore backup"})
+			return
+		}
+		Render(c, http.StatusOK, models.APIResponse{Message: "Backup restored"})
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/atrakic/gin-sqlite/internal/compression"
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// DecompressBody decodes request bodies sent with a gzip, zstd or br
+// Content-Encoding, answering 415 for other codings
+func DecompressBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		coding := c.GetHeader("Content-Encoding")
+		if coding == "" || coding == "identity" || c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+
+		body, err := compression.NewReader(coding, c.Request.Body)
+		if errors.Is(err, compression.ErrUnsupportedEncoding) {
+			Render(c, http.StatusUnsupportedMediaType, models.APIResponse{
+				Error: "Unsupported Content-Encoding, supported codings: gzip, zstd, br",
+			})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			Render(c, http.StatusBadRequest, models.APIResponse{Error: "Malformed compressed body: " + err.Error()})
+			c.Abort()
+			return
+		}
+		defer body.Close()
+
+		c.Request.Body = body
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.ContentLength = -1
+		c.Next()
+	}
+}
+
+// MaxBodySize caps request bodies at limit bytes, once decompressed when it
+// runs after DecompressBody; reading past it fails with *http.MaxBytesError,
+// which handlers answer with 413
+func MaxBodySize(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			renderTooLarge(c)
+			c.Abort()
+			return
+		}
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		}
+		c.Next()
+	}
+}
+
+// isTooLarge reports whether err comes from reading past MaxBodySize
+func isTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+func renderTooLarge(c *gin.Context) {
+	Render(c, http.StatusRequestEntityTooLarge, models.APIResponse{Error: "Request body too large"})
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// changesQuery holds the query parameters of GetPersonChanges
+type changesQuery struct {
+	Since int64 `form:"since" binding:"min=0"`
+	Limit int   `form:"limit" binding:"min=1,max=1000"`
+}
+
+// GetPersonChanges returns the person change feed for incremental sync
+// @Summary Get the person change feed
+// @Description Get the changes to persons after a sequence number, in order. Inserts and updates carry the person as it was after the change,
+// @Description deletes are tombstones with only the person ID. Start with since=0 to get every person, then keep following the next link,
+// @Description which points after the last change returned.
+// @Tags persons
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Param since query int false "Return the changes after this sequence number (default: 0)" minimum(0)
+// @Param limit query int false "Maximum number of changes (default: 100)" minimum(1) maximum(1000)
+// @Success 200 {object} models.APIResponse{data=[]models.PersonChange} "Changes, with a link to the next ones"
+// @Failure 400 {object} models.APIResponse "Invalid query parameters"
+// @Failure 401 {object} models.APIResponse "Not authenticated"
+// @Failure 406 {object} models.APIResponse "Not acceptable"
+// @Failure 500 {object} models.APIResponse "Internal server error"
+// @Security BearerAuth
+// @Router /api/v1/changes [get]
+// @Router /api/v2/changes [get]
+func GetPersonChanges(c *gin.Context) {
+	query := changesQuery{Limit: 100}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: "Invalid query parameters: " + err.Error()})
+		return
+	}
+
+	changes, err := database.DbGetPersonChanges(c.Request.Context(), query.Since, query.Limit)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Reading the change feed failed", "error", err)
+		Render(c, http.StatusInternalServerError, models.APIResponse{Error: "Failed to retrieve changes"})
+		return
+	}
+
+	next := query.Since
+	if len(changes) > 0 {
+		next = changes[len(changes)-1].Seq
+	}
+	Render(c, http.StatusOK, models.APIResponse{
+		Data: changes,
+		Links: &models.Links{
+			Self: c.Request.URL.RequestURI(),
+			Next: c.Request.URL.Path + "?" + url.Values{
+				"since": {strconv.FormatInt(next, 10)},
+				"limit": {strconv.Itoa(query.Limit)},
+			}.Encode(),
+		},
+	})
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// includeFunc loads a related resource for the given persons, to be returned
+// under "included" when requested with ?include=<name>
+type includeFunc func(c *gin.Context, persons []models.Person) (interface{}, error)
+
+// personIncludes registers the related resources available on person reads
+var personIncludes = map[string]includeFunc{}
+
+// parseFields parses the comma separated ?fields= list, returning nil when all
+// fields are wanted
+func parseFields(c *gin.Context) ([]string, error) {
+	fields := splitList(c.Query("fields"))
+	for _, field := range fields {
+		if !slices.Contains(models.PersonFields, field) {
+			return nil, fmt.Errorf("unknown field %q, allowed fields: %s", field, strings.Join(models.PersonFields, ","))
+		}
+	}
+	return fields, nil
+}
+
+// parseIncludes parses the comma separated ?include= list
+func parseIncludes(c *gin.Context) ([]string, error) {
+	includes := splitList(c.Query("include"))
+	for _, include := range includes {
+		if _, ok := personIncludes[include]; !ok {
+			return nil, fmt.Errorf("unknown include %q", include)
+		}
+	}
+	return includes, nil
+}
+
+// loadIncludes resolves the requested related resources for the given persons
+func loadIncludes(c *gin.Context, includes []string, persons []models.Person) (models.Record, error) {
+	if len(includes) == 0 {
+		return nil, nil
+	}
+
+	included := make(models.Record, len(includes))
+	for _, include := range includes {
+		related, err := personIncludes[include](c, persons)
+		if err != nil {
+			return nil, err
+		}
+		included[include] = related
+	}
+	return included, nil
+}
+
+// projectPersons trims persons down to the requested fields, if any
+func projectPersons(persons []models.Person, fields []string) interface{} {
+	if len(fields) == 0 {
+		return persons
+	}
+
+	records := make([]models.Record, len(persons))
+	for i, person := range persons {
+		records[i] = person.Project(fields)
+	}
+	return records
+}
+
+// splitList splits a comma separated query value, dropping blanks and duplicates
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" && !slices.Contains(items, item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/health"
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Healthz reports that the process is alive
+// @Summary Liveness probe
+// @Description Returns ok as long as the process can serve requests, without checking dependencies
+// @Tags health
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Success 200 {object} models.APIResponse{data=health.Report} "Process alive"
+// @Router /healthz [get]
+func Healthz(c *gin.Context) {
+	Render(c, http.StatusOK, models.APIResponse{
+		Data: health.Report{
+			Status:    health.StatusOK,
+			Timestamp: time.Now().UTC(),
+			Checks:    []health.Result{},
+		},
+	})
+}
+
+// Readyz returns a handler reporting whether the dependencies checked by
+// checker are ready to serve traffic
+// @Summary Readiness probe
+// @Description Checks the database connection, schema version, free disk space and WAL size, with the status and latency of each check
+// @Tags health
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Success 200 {object} models.APIResponse{data=health.Report} "All checks passed"
+// @Failure 503 {object} models.APIResponse{data=health.Report} "At least one check failed"
+// @Router /readyz [get]
+func Readyz(checker *health.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := checker.Run(c.Request.Context())
+
+		code := http.StatusOK
+		if report.Status != health.StatusOK {
+			code = http.StatusServiceUnavailable
+		}
+		Render(c, code, models.APIResponse{Data: report})
+	}
+}
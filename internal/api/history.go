@@ -0,0 +1,109 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// GetPersonHistory returns the revisions of a person
+// @Summary Get the history of a person
+// @Description Get every revision of a person, oldest first. A revision is recorded on each create, update, delete and restore.
+// @Tags persons
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Param id path int true "Person ID"
+// @Param include_deleted query bool false "Also find a deleted person, admin only"
+// @Success 200 {object} models.APIResponse{data=[]models.PersonRevision} "Revisions of the person"
+// @Failure 400 {object} models.APIResponse "Invalid ID or include_deleted parameter"
+// @Failure 401 {object} models.APIResponse "Not authenticated, with include_deleted"
+// @Failure 403 {object} models.APIResponse "Not an admin, with include_deleted"
+// @Failure 404 {object} models.APIResponse "Person not found"
+// @Failure 406 {object} models.APIResponse "Not acceptable"
+// @Failure 500 {object} models.APIResponse "Internal server error"
+// @Router /api/v1/person/{id}/history [get]
+// @Router /api/v2/person/{id}/history [get]
+func GetPersonHistory(c *gin.Context) {
+	personID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: "Invalid ID"})
+		return
+	}
+
+	deleted, err := includeDeleted(c)
+	if err != nil {
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: err.Error()})
+		return
+	}
+
+	revisions, err := database.DbGetPersonHistory(c.Request.Context(), personID, deleted)
+	if err != nil {
+		checkErr(c, err)
+		Render(c, http.StatusInternalServerError, models.APIResponse{Error: "Failed to retrieve history"})
+		return
+	}
+	if len(revisions) == 0 {
+		Render(c, http.StatusNotFound, models.APIResponse{Error: "No Records Found"})
+		return
+	}
+
+	Render(c, http.StatusOK, models.APIResponse{Data: revisions, Links: selfLinks(c)})
+}
+
+// RevertPerson sets a person back to one of its revisions
+// @Summary Revert a person to a revision
+// @Description Set the name and email of a person back to those of one of its revisions, restoring the person when it was deleted.
+// @Description The revert is recorded as a new revision.
+// @Tags persons
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Param id path int true "Person ID"
+// @Param revision path int true "Revision to revert to"
+// @Success 200 {object} models.APIResponse{data=models.Person} "Reverted person"
+// @Failure 400 {object} models.APIResponse "Invalid ID or revision"
+// @Failure 404 {object} models.APIResponse "No such revision of the person"
+// @Failure 406 {object} models.APIResponse "Not acceptable"
+// @Failure 409 {object} models.APIResponse "Email taken by another person meanwhile"
+// @Failure 500 {object} models.APIResponse "Internal server error"
+// @Failure 503 {object} models.APIResponse "Read-only maintenance in progress, with a Retry-After header"
+// @Security BearerAuth
+// @Router /api/v1/person/{id}/history/{revision}/revert [post]
+// @Router /api/v2/person/{id}/history/{revision}/revert [post]
+func RevertPerson(c *gin.Context) {
+	personID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: "Invalid ID"})
+		return
+	}
+	revision, err := strconv.ParseInt(c.Param("revision"), 10, 64)
+	if err != nil {
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: "Invalid revision"})
+		return
+	}
+
+	reverted, err := database.DbRevertPerson(c.Request.Context(), personID, revision, username(c))
+	if err != nil {
+		checkErr(c, err)
+		renderWriteError(c, err)
+		return
+	}
+	if !reverted {
+		Render(c, http.StatusNotFound, models.APIResponse{Error: "No such revision of this person"})
+		return
+	}
+
+	person, err := database.DbGetPersonByID(c.Request.Context(), c.Param("id"), nil, false)
+	if err != nil {
+		checkErr(c, err)
+		Render(c, http.StatusInternalServerError, models.APIResponse{Error: "Failed to retrieve person"})
+		return
+	}
+	path, _, _ := strings.Cut(c.Request.URL.Path, "/history/")
+	Render(c, http.StatusOK, models.APIResponse{
+		Data:    person,
+		Links:   resourceLinks(path),
+		Message: "id #" + strconv.Itoa(personID) + " reverted to revision " + strconv.FormatInt(revision, 10),
+	})
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// selfLinks returns links pointing at the current request
+func selfLinks(c *gin.Context) *models.Links {
+	return &models.Links{Self: c.Request.URL.RequestURI()}
+}
+
+// resourceLinks returns links pointing at the given resource path
+func resourceLinks(path string) *models.Links {
+	return &models.Links{Self: path}
+}
+
+// paginationLinks returns links to the current, first, last and adjacent
+// pages, keeping every other query parameter of the current request
+func paginationLinks(c *gin.Context, meta models.PaginationMeta) *models.Links {
+	pageURL := func(page int) string {
+		u := *c.Request.URL
+		query := u.Query()
+		query.Set("page", strconv.Itoa(page))
+		query.Set("page_size", strconv.Itoa(meta.PageSize))
+		u.RawQuery = query.Encode()
+		return u.RequestURI()
+	}
+
+	links := &models.Links{
+		Self:  pageURL(meta.CurrentPage),
+		First: pageURL(1),
+		Last:  pageURL(meta.TotalPages),
+	}
+	if meta.HasPrevPage {
+		links.Prev = pageURL(min(meta.CurrentPage-1, meta.TotalPages))
+	}
+	if meta.HasNextPage {
+		links.Next = pageURL(meta.CurrentPage + 1)
+	}
+	return links
+}
+
+// setLinkHeader sends links as an RFC 8288 Link header, alongside any Link
+// already set by middleware
+func setLinkHeader(c *gin.Context, links *models.Links) {
+	if links == nil {
+		return
+	}
+
+	var values []string
+	for _, link := range []struct{ rel, href string }{
+		{"self", links.Self},
+		{"first", links.First},
+		{"prev", links.Prev},
+		{"next", links.Next},
+		{"last", links.Last},
+	} {
+		if link.href != "" {
+			values = append(values, "<"+link.href+`>; rel="`+link.rel+`"`)
+		}
+	}
+	c.Writer.Header().Add("Link", strings.Join(values, ", "))
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// jobRunsQuery holds the query parameters of ListJobRuns
+type jobRunsQuery struct {
+	Job   string `form:"job"`
+	Limit int    `form:"limit" binding:"min=1,max=1000"`
+}
+
+// ListJobRuns returns the history of the database maintenance jobs
+// @Summary List maintenance job runs
+// @Description List the runs of the scheduled database maintenance jobs, newest first
+// @Tags admin
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Param job query string false "Only runs of this job" Enums(checkpoint, vacuum, optimize, integrity_check, purge_deleted)
+// @Param limit query int false "Maximum number of runs (default: 100)" minimum(1) maximum(1000)
+// @Success 200 {object} models.APIResponse{data=[]models.JobRun} "Job runs"
+// @Failure 400 {object} models.APIResponse "Invalid query parameters"
+// @Failure 401 {object} models.APIResponse "Not authenticated"
+// @Failure 403 {object} models.APIResponse "Not an admin"
+// @Failure 500 {object} models.APIResponse "Internal server error"
+// @Security BearerAuth
+// @Router /admin/jobs [get]
+func ListJobRuns(c *gin.Context) {
+	query := jobRunsQuery{Limit: 100}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		Render(c, http.StatusBadRequest, models.APIResponse{Error: "Invalid query parameters: " + err.Error()})
+		return
+	}
+
+	runs, err := database.DbGetJobRuns(c.Request.Context(), query.Job, query.Limit)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Listing job runs failed", "error", err)
+		Render(c, http.StatusInternalServerError, models.APIResponse{Error: "Failed to list job runs"})
+		return
+	}
+	Render(c, http.StatusOK, models.APIResponse{Data: runs})
+}
+
+// RejectInMaintenance answers writes with 503 and a Retry-After header while
+// the database is in maintenance mode
+func RejectInMaintenance(c *gin.Context) {
+	if on, retryAfter := database.InMaintenance(); on {
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+		Render(c, http.StatusServiceUnavailable, models.APIResponse{Error: "Read-only maintenance in progress, retry later"})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// GetMaintenance reports whether the database is in maintenance mode
+// @Summary Get maintenance mode
+// @Description Report whether the database is in read-only maintenance mode
+// @Tags admin
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Success 200 {object} models.APIResponse{data=models.MaintenanceStatus} "Maintenance mode status"
+// @Failure 401 {object} models.APIResponse "Not authenticated"
+// @Failure 403 {object} models.APIResponse "Not an admin"
+// @Security BearerAuth
+// @Router /admin/maintenance [get]
+func GetMaintenance(c *gin.Context) {
+	Render(c, http.StatusOK, models.APIResponse{Data: database.MaintenanceStatus()})
+}
+
+// EnterMaintenance returns a handler putting the database in maintenance
+// mode, telling clients to retry writes after retryAfter unless the request
+// says otherwise
+// @Summary Enter maintenance mode
+// @Description Put the database in read-only maintenance mode: reads are served from a read-only connection and writes answered with 503 and a Retry-After header. Also toggled with SIGUSR1.
+// @Tags admin
+// @Accept json,xml,application/x-yaml,application/x-msgpack
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Param request body models.MaintenanceRequest false "Retry-After to send"
+// @Success 200 {object} models.APIResponse{data=models.MaintenanceStatus} "In maintenance mode"
+// @Failure 400 {object} models.APIResponse "Invalid input"
+// @Failure 401 {object} models.APIResponse "Not authenticated"
+// @Failure 403 {object} models.APIResponse "Not an admin"
+// @Failure 500 {object} models.APIResponse "Internal server error"
+// @Security BearerAuth
+// @Router /admin/maintenance [post]
+func EnterMaintenance(retryAfter time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.MaintenanceRequest
+		if c.Request.ContentLength != 0 {
+			if err := bindBody(c, &req); err != nil {
+				renderBindError(c, err)
+				return
+			}
+		}
+		d := retryAfter
+		if req.RetryAfter > 0 {
+			d = time.Duration(req.RetryAfter) * time.Second
+		}
+
+		if err := database.EnterMaintenance(c.Request.Context(), d); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Entering maintenance mode failed", "error", err)
+			Render(c, http.StatusInternalServerError, models.APIResponse{Error: "Failed to enter maintenance mode"})
+			return
+		}
+		Render(c, http.StatusOK, models.APIResponse{Data: database.MaintenanceStatus(), Message: "Maintenance mode entered"})
+	}
+}
+
+// LeaveMaintenance takes the database out of maintenance mode
+// @Summary Leave maintenance mode
+// @Description Take the database out of read-only maintenance mode, accepting writes again
+// @Tags admin
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Success 200 {object} models.APIResponse{data=models.MaintenanceStatus} "Out of maintenance mode"
+// @Failure 401 {object} models.APIResponse "Not authenticated"
+// @Failure 403 {object} models.APIResponse "Not an admin"
+// @Failure 500 {object} models.APIResponse "Internal server error"
+// @Security BearerAuth
+// @Router /admin/maintenance [delete]
+func LeaveMaintenance(c *gin.Context) {
+	if err := database.LeaveMaintenance(c.Request.Context()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Leaving maintenance mode failed", "error", err)
+		Render(c, http.StatusInternalServerError, models.APIResponse{Error: "Failed to leave maintenance mode"})
+		return
+	}
+	Render(c, http.StatusOK, models.APIResponse{Data: database.MaintenanceStatus(), Message: "Maintenance mode left"})
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/auth"
+	"github.com/atrakic/gin-sqlite/internal/certs"
+	"github.com/atrakic/gin-sqlite/internal/metrics"
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/atrakic/gin-sqlite/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit limits requests per route and client, answering 429 Too Many
+// Requests once the client's bucket is empty. Responses of limited routes
+// carry RateLimit-* headers (draft-ietf-httpapi-ratelimit-headers).
+func RateLimit(limiter *ratelimit.Limiter, apiKeyHeader string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		result, err := limiter.Allow(c.Request.Context(), c.Request.Method, route, clientKey(c, apiKeyHeader))
+		if err != nil {
+			// Let the request through rather than fail on a store error
+			slog.WarnContext(c.Request.Context(), "Rate limit store error", "error", err)
+		}
+		if result.Limit.Unlimited() {
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", ceilSeconds(result.Reset))
+		c.Header("RateLimit-Policy", strconv.Itoa(result.Limit.Requests)+";w="+ceilSeconds(result.Limit.Period))
+
+		if !result.Allowed {
+			metrics.RateLimited.WithLabelValues(c.Request.Method, route).Inc()
+			retryAfter := ceilSeconds(result.RetryAfter)
+			c.Header("Retry-After", retryAfter)
+			Render(c, http.StatusTooManyRequests, models.APIResponse{
+				Error: "Too many requests, retry in " + retryAfter + " seconds",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// clientKey identifies the client by username when it sent a verified TLS
+// certificate or a valid token, else by API key when the header is
+// configured and set, else by IP address
+func clientKey(c *gin.Context, apiKeyHeader string) string {
+	if username, ok := certs.ClientUsername(c.Request.TLS); ok {
+		return "user:" + username
+	}
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		if claims, err := auth.ValidateJWT(token); err == nil {
+			return "user:" + claims.Username
+		}
+	}
+	if apiKeyHeader != "" {
+		if key := c.GetHeader(apiKeyHeader); key != "" {
+			// Don't keep API keys around in the bucket store
+			sum := sha256.Sum256([]byte(key))
+			return "key:" + hex.EncodeToString(sum[:16])
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// ceilSeconds formats d as whole seconds, rounded up
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
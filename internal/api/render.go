@@ -0,0 +1,115 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+)
+
+// Offered response media types, the first one is the default
+var offeredFormats = []string{
+	binding.MIMEJSON,
+	binding.MIMEXML,
+	binding.MIMEXML2,
+	binding.MIMEYAML,
+	binding.MIMEYAML2,
+	binding.MIMEMSGPACK,
+	binding.MIMEMSGPACK2,
+}
+
+// errUnsupportedMediaType is returned by bindBody for request bodies we can't decode
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// Render writes the response envelope in the format negotiated from the Accept
+// header, answering 406 Not Acceptable when none of the offered formats match.
+// API v1 routes get the bodies they had before the envelope instead.
+func Render(c *gin.Context, code int, obj models.APIResponse) {
+	obj.Meta.APIVersion = apiVersion(c)
+	setLinkHeader(c, obj.Links)
+
+	if !v1Bodies(c) {
+		renderBody(c, code, obj)
+		return
+	}
+	if obj.Meta.Pagination != nil {
+		renderBody(c, code, models.PaginatedResponse{Data: obj.Data, Included: obj.Included, Pagination: *obj.Meta.Pagination})
+		return
+	}
+	renderBody(c, code, models.V1Response{Data: obj.Data, Included: obj.Included, Message: obj.Message, Error: obj.Error})
+}
+
+// renderBody writes body in the format negotiated from the Accept header
+func renderBody(c *gin.Context, code int, body any) {
+	format := c.NegotiateFormat(offeredFormats...)
+
+	switch format {
+	case binding.MIMEJSON:
+		c.JSON(code, body)
+	case binding.MIMEXML, binding.MIMEXML2:
+		c.XML(code, body)
+	case binding.MIMEYAML, binding.MIMEYAML2:
+		c.YAML(code, body)
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		c.Render(code, render.MsgPack{Data: body})
+	default:
+		const message = "Not acceptable, supported formats: JSON, XML, YAML, MessagePack"
+		if v1Bodies(c) {
+			c.JSON(http.StatusNotAcceptable, models.V1Response{Error: message})
+			return
+		}
+		c.JSON(http.StatusNotAcceptable, models.APIResponse{
+			Meta:  models.Meta{APIVersion: apiVersion(c)},
+			Error: message,
+		})
+	}
+}
+
+// Acceptable answers 406 Not Acceptable before the handler runs when the
+// Accept header matches none of the offered formats, so that writes aren't
+// made for clients that can't read the answer
+func Acceptable(c *gin.Context) {
+	if c.NegotiateFormat(offeredFormats...) == "" {
+		Render(c, http.StatusNotAcceptable, models.APIResponse{})
+		c.Abort()
+	}
+}
+
+// bindBody decodes the request body according to its Content-Type,
+// treating a missing Content-Type as JSON
+func bindBody(c *gin.Context, obj any) error {
+	var b binding.Binding
+
+	switch c.ContentType() {
+	case "", binding.MIMEJSON:
+		b = binding.JSON
+	case binding.MIMEXML, binding.MIMEXML2:
+		b = binding.XML
+	case binding.MIMEYAML, binding.MIMEYAML2:
+		b = binding.YAML
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		b = binding.MsgPack
+	default:
+		return errUnsupportedMediaType
+	}
+
+	return c.ShouldBindWith(obj, b)
+}
+
+// renderBindError answers a failed bindBody with 413, 415 or 400
+func renderBindError(c *gin.Context, err error) {
+	if isTooLarge(err) {
+		renderTooLarge(c)
+		return
+	}
+	if errors.Is(err, errUnsupportedMediaType) {
+		Render(c, http.StatusUnsupportedMediaType, models.APIResponse{
+			Error: "Unsupported Content-Type, supported formats: JSON, XML, YAML, MessagePack",
+		})
+		return
+	}
+	Render(c, http.StatusBadRequest, models.APIResponse{Error: err.Error()})
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/atrakic/gin-sqlite/internal/vcard"
+	"github.com/gin-gonic/gin"
+)
+
+// GetPersonVCard writes a single person as a vCard
+func GetPersonVCard(c *gin.Context, id string) {
+	person, err := database.DbGetPersonByID(c.Request.Context(), id, nil, false)
+	checkErr(c, err)
+
+	if person.ID == 0 {
+		Render(c, http.StatusNotFound, models.APIResponse{Error: "No Records Found"})
+		return
+	}
+
+	renderVCard(c, fmt.Sprintf("person-%d.vcf", person.ID), person)
+}
+
+// ExportPersonsVCard exports all persons as a vCard file
+// @Summary Export persons as vCard
+// @Description Export all persons as a vCard 4.0 file
+// @Tags persons
+// @Produce text/vcard
+// @Success 200 {string} string "vCard file"
+// @Failure 500 {object} models.APIResponse "Internal server error"
+// @Router /api/v1/person.vcf [get]
+// @Router /api/v2/person.vcf [get]
+func ExportPersonsVCard(c *gin.Context) {
+	persons, err := database.DbGetAllPersons(c.Request.Context())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Database error getting persons", "error", err)
+		Render(c, http.StatusInternalServerError, models.APIResponse{
+			Error: "Failed to retrieve persons",
+		})
+		return
+	}
+
+	renderVCard(c, "persons.vcf", persons...)
+}
+
+// ImportPersonsVCard imports persons from a vCard file
+// @Summary Import persons from vCard
+// @Description Create persons from a vCard file, mapping FN/N/EMAIL to person fields. Cards without an email or clashing with an existing email are skipped.
+// @Description Any other failure stops the import, answering 500 with the number of persons imported before it; those are kept.
+// @Tags persons
+// @Accept text/vcard
+// @Produce json,xml,application/x-yaml,application/x-msgpack
+// @Param vcard body string true "vCard file"
+// @Success 200 {object} models.APIResponse{data=models.ImportResult} "Import summary"
+// @Failure 400 {object} models.APIResponse "Malformed vCard"
+// @Failure 406 {object} models.APIResponse "Not acceptable"
+// @Failure 413 {object} models.APIResponse "Request body too large"
+// @Failure 500 {object} models.APIResponse{data=models.ImportResult} "Import stopped by an error, with the summary up to it"
+// @Failure 503 {object} models.APIResponse "Read-only maintenance in progress, with a Retry-After header"
+// @Security BearerAuth
+// @Router /api/v1/person.vcf [post]
+// @Router /api/v2/person.vcf [post]
+func ImportPersonsVCard(c *gin.Context) {
+	persons, err := vcard.Decode(c.Request.Body)
+	if err != nil {
+		if isTooLarge(err) {
+			renderTooLarge(c)
+			return
+		}
+		Render(c, http.StatusBadRequest, models.APIResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	result := models.ImportResult{}
+	for _, person := range persons {
+		if person.Email == "" {
+			result.Skipped++
+			continue
+		}
+		if _, err := database.DbAddPerson(c.Request.Context(), person, username(c)); err != nil {
+			if !errors.Is(err, database.ErrDuplicate) {
+				checkErr(c, err)
+				Render(c, http.StatusInternalServerError, models.APIResponse{
+					Data:  result,
+					Error: fmt.Sprintf("Failed to save person, import stopped after %d of %d persons", result.Imported, len(persons)),
+				})
+				return
+			}
+			slog.WarnContext(c.Request.Context(), "Skipping vCard", "email", person.Email, "error", err)
+			result.Skipped++
+			continue
+		}
+		result.Imported++
+	}
+
+	Render(c, http.StatusOK, models.APIResponse{
+		Data:    result,
+		Message: fmt.Sprintf("Imported %d of %d persons", result.Imported, len(persons)),
+	})
+}
+
+func renderVCard(c *gin.Context, filename string, persons ...models.Person) {
+	var buf bytes.Buffer
+	if err := vcard.Encode(&buf, persons...); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to encode vCard", "error", err)
+		Render(c, http.StatusInternalServerError, models.APIResponse{
+			Error: "Failed to encode vCard",
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, vcard.MIMEType+"; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// API versions served side by side, handlers are shared and branch on the
+// version where v2 fixes v1 behaviour existing clients may rely on
+const (
+	V1 = "v1"
+	V2 = "v2"
+)
+
+// versionKey is the gin context key holding the API version of a request
+const versionKey = "api_version"
+
+// Version tags requests of a route group with the given API version
+func Version(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(versionKey, version)
+		c.Next()
+	}
+}
+
+// Deprecated marks a route group as deprecated: responses carry Deprecation
+// (RFC 9745) and Sunset (RFC 8594) headers plus a successor-version link to the
+// same route under successorPrefix, and usage is counted per route
+func Deprecated(deprecation, sunset time.Time, prefix, successorPrefix string) gin.HandlerFunc {
+	deprecationValue := "@" + strconv.FormatInt(deprecation.Unix(), 10)
+	sunsetValue := sunset.UTC().Format(http.TimeFormat)
+
+	return func(c *gin.Context) {
+		c.Header("Deprecation", deprecationValue)
+		c.Header("Sunset", sunsetValue)
+		if successor, ok := strings.CutPrefix(c.Request.URL.Path, prefix); ok {
+			c.Writer.Header().Add("Link", "<"+successorPrefix+successor+`>; rel="successor-version"`)
+		}
+
+		metrics.DeprecatedRequests.WithLabelValues(c.Request.Method, c.FullPath()).Inc()
+		c.Next()
+	}
+}
+
+// apiVersion returns the API version of the request, defaulting to v1 for
+// routes outside a versioned group
+func apiVersion(c *gin.Context) string {
+	if version := c.GetString(versionKey); version != "" {
+		return version
+	}
+	return V1
+}
+
+// v1Bodies reports whether the request is answered with the API v1 bodies
+// from before the envelope: only routes tagged as v1 are, unversioned routes
+// added since answer with the envelope
+func v1Bodies(c *gin.Context) bool {
+	return c.GetString(versionKey) == V1
+}
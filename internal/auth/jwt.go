@@ -2,7 +2,6 @@ package auth
 
 import (
 	"errors"
-	"os"
 	"time"
 
 	"github.com/atrakic/gin-sqlite/internal/models"
@@ -10,15 +9,19 @@ import (
 )
 
 var (
-	// Default JWT secret key - should be set via environment variable in production
+	// Default JWT secret key - should be set via configuration in production
 	jwtSecret = []byte("your-secret-key")
+
+	// Default admin credentials - should be set via configuration in production
+	adminUser     = "admin"
+	adminPassword = "secret"
 )
 
-func init() {
-	// Override with environment variable if set
-	if secret := os.Getenv("JWT_SECRET"); secret != "" {
-		jwtSecret = []byte(secret)
-	}
+// Configure sets the JWT signing secret and the admin credentials
+func Configure(secret, user, password string) {
+	jwtSecret = []byte(secret)
+	adminUser = user
+	adminPassword = password
 }
 
 // GenerateJWT generates a JWT token for the given username
@@ -68,16 +71,10 @@ func ValidateJWT(tokenString string) (*models.JWTClaims, error) {
 
 // ValidateCredentials validates username and password
 func ValidateCredentials(username, password string) bool {
-	// Get credentials from environment variables
-	adminUser := os.Getenv("ADMIN_USER")
-	if adminUser == "" {
-		adminUser = "admin"
-	}
-
-	adminPassword := os.Getenv("ADMIN_PASSWORD")
-	if adminPassword == "" {
-		adminPassword = "secret"
-	}
-
 	return username == adminUser && password == adminPassword
 }
+
+// IsAdmin reports whether username is the admin user
+func IsAdmin(username string) bool {
+	return username == adminUser
+}
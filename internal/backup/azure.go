@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// AzureOptions configures an AzureTarget
+type AzureOptions struct {
+	Account string
+	Key     string
+	// Endpoint is the blob service URL, by default that of Account in the
+	// public cloud; Azurite serves http://127.0.0.1:10000/devstoreaccount1
+	Endpoint  string
+	Container string
+	// Prefix is prepended to blob names
+	Prefix string
+}
+
+// AzureTarget stores backups as block blobs in an Azure Storage container
+type AzureTarget struct {
+	client    *azblob.Client
+	container string
+	prefix    string
+}
+
+// NewAzureTarget returns a target storing backups in opts.Container,
+// authenticating with the account's shared key
+func NewAzureTarget(opts AzureOptions) (*AzureTarget, error) {
+	cred, err := azblob.NewSharedKeyCredential(opts.Account, opts.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + opts.Account + ".blob.core.windows.net/"
+	}
+	client, err := azblob.NewClientWithSharedKeyCredential(endpoint, cred, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AzureTarget{client: client, container: opts.Container, prefix: opts.Prefix}, nil
+}
+
+// Upload puts the blob with a CRC64 the service verifies, and the SHA-256
+// checksum as metadata
+func (t *AzureTarget) Upload(ctx context.Context, name string, r io.ReadSeeker, size int64, sum string) error {
+	metadata := map[string]*string{checksumMetadata: &sum}
+
+	if size > blockblob.MaxUploadBlobBytes {
+		_, err := t.client.UploadStream(ctx, t.container, t.prefix+name, r, &azblob.UploadStreamOptions{
+			Metadata: metadata,
+		})
+		return err
+	}
+
+	client := t.client.ServiceClient().NewContainerClient(t.container).NewBlockBlobClient(t.prefix + name)
+	_, err := client.Upload(ctx, streaming.NopCloser(r), &blockblob.UploadOptions{
+		Metadata:                metadata,
+		TransactionalValidation: blob.TransferValidationTypeComputeCRC64(),
+	})
+	return err
+}
+
+// Download gets the blob along with its checksum metadata
+func (t *AzureTarget) Download(ctx context.Context, name string) (io.ReadCloser, string, error) {
+	resp, err := t.client.DownloadStream(ctx, t.container, t.prefix+name, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil, "", fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	var sum string
+	for k, v := range resp.Metadata {
+		if strings.EqualFold(k, checksumMetadata) && v != nil {
+			sum = *v
+		}
+	}
+	return resp.Body, sum, nil
+}
+
+// List lists the blobs below the target prefix starting with prefix
+func (t *AzureTarget) List(ctx context.Context, prefix string) ([]Object, error) {
+	full := t.prefix + prefix
+	pager := t.client.NewListBlobsFlatPager(t.container, &container.ListBlobsFlatOptions{Prefix: &full})
+
+	var objects []Object
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Segment.BlobItems {
+			object := Object{Name: strings.TrimPrefix(*item.Name, t.prefix)}
+			if item.Properties != nil && item.Properties.ContentLength != nil {
+				object.Size = *item.Properties.ContentLength
+			}
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+// Delete removes the blob
+func (t *AzureTarget) Delete(ctx context.Context, name string) error {
+	_, err := t.client.DeleteBlob(ctx, t.container, t.prefix+name, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil
+	}
+	return err
+}
+
+// String describes the target in logs
+func (t *AzureTarget) String() string {
+	return fmt.Sprintf("azure:%s%s/%s", t.client.URL(), t.container, t.prefix)
+}
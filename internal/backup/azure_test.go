@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Azurite's well-known development account
+const (
+	azuriteAccount = "devstoreaccount1"
+	azuriteKey     = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// azuriteStandIn is an in-memory Blob service API covering the requests the
+// Azure target makes, laid out like Azurite
+type azuriteStandIn struct {
+	mu    sync.Mutex
+	blobs map[string]storedObject
+}
+
+func (s *azuriteStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/"+azuriteAccount+"/")
+	container, name, _ := strings.Cut(path, "/")
+	if container != "backups" {
+		azureError(w, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+
+	w.Header().Set("x-ms-version", r.Header.Get("x-ms-version"))
+	switch {
+	case r.Method == http.MethodGet && name == "" && r.URL.Query().Get("comp") == "list":
+		s.list(w, r.URL.Query().Get("prefix"))
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			azureError(w, http.StatusBadRequest, "InvalidInput")
+			return
+		}
+		metadata := http.Header{}
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, "X-Ms-Meta-") {
+				metadata[k] = v
+			}
+		}
+		s.blobs[name] = storedObject{data: data, metadata: metadata}
+		w.Header().Set("ETag", `"`+strconv.Quote(name)+`"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet:
+		blob, ok := s.blobs[name]
+		if !ok {
+			azureError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		for k, v := range blob.metadata {
+			w.Header()[k] = v
+		}
+		w.Header().Set("ETag", `"`+strconv.Quote(name)+`"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(blob.data)))
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		_, _ = w.Write(blob.data)
+	case r.Method == http.MethodDelete:
+		if _, ok := s.blobs[name]; !ok {
+			azureError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(s.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		azureError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *azuriteStandIn) list(w http.ResponseWriter, prefix string) {
+	type blob struct {
+		Name       string
+		Properties struct {
+			ContentLength int64 `xml:"Content-Length"`
+		}
+	}
+	result := struct {
+		XMLName    xml.Name `xml:"EnumerationResults"`
+		Prefix     string
+		Blobs      []blob `xml:"Blobs>Blob"`
+		NextMarker string
+	}{Prefix: prefix}
+
+	for name, stored := range s.blobs {
+		if strings.HasPrefix(name, prefix) {
+			b := blob{Name: name}
+			b.Properties.ContentLength = int64(len(stored.data))
+			result.Blobs = append(result.Blobs, b)
+		}
+	}
+	slices.SortFunc(result.Blobs, func(a, b blob) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func azureError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+	}{Code: code})
+}
+
+// TestAzureTarget runs against an in-memory stand-in, or the Azurite blob
+// endpoint given by AZURITE_BLOB_ENDPOINT, such as
+// http://127.0.0.1:10000/devstoreaccount1, with an existing backups container
+func TestAzureTarget(t *testing.T) {
+	endpoint := os.Getenv("AZURITE_BLOB_ENDPOINT")
+	if endpoint == "" {
+		server := httptest.NewServer(&azuriteStandIn{blobs: make(map[string]storedObject)})
+		t.Cleanup(server.Close)
+		endpoint = server.URL + "/" + azuriteAccount
+	}
+
+	target, err := NewAzureTarget(AzureOptions{
+		Account:   azuriteAccount,
+		Key:       azuriteKey,
+		Endpoint:  endpoint + "/",
+		Container: "backups",
+		Prefix:    "test/",
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ctx := context.Background()
+		objects, _ := target.List(ctx, "")
+		for _, o := range objects {
+			_ = target.Delete(ctx, o.Name)
+		}
+	})
+
+	testTarget(t, target)
+}
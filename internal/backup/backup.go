@@ -0,0 +1,454 @@
+// Package backup takes online, integrity checked and compressed backups of
+// the SQLite database, prunes them with a rolling hourly and daily retention
+// policy and restores them
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/compression"
+	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressions selectable for new backups; restores detect the compression
+// from the file extension
+const (
+	CompressionNone = "none"
+	CompressionGzip = compression.Gzip
+	CompressionZstd = compression.Zstd
+)
+
+// Compressions lists the supported backup compressions
+var Compressions = []string{CompressionNone, CompressionGzip, CompressionZstd}
+
+// Backup names match the ones docker/backup/backup.sh used to write, so
+// backups taken by the script are listed and pruned as well
+const (
+	namePrefix = "db_backup_"
+	nameLayout = "2006-01-02_15-04-05"
+	nameExt    = ".sqlite"
+)
+
+// extensions maps compressions to the suffix appended to nameExt
+var extensions = map[string]string{
+	CompressionNone: "",
+	CompressionGzip: ".gz",
+	CompressionZstd: ".zst",
+}
+
+// ErrNotFound is returned when restoring a backup that doesn't exist
+var ErrNotFound = errors.New("backup not found")
+
+// Info describes a backup file
+// @Description Database backup
+type Info struct {
+	Name    string    `json:"name" xml:"name" example:"db_backup_2026-10-19_14-00-00.sqlite.gz"` // File name
+	Size    int64     `json:"size" xml:"size" example:"8192"`                                    // Size in bytes
+	Created time.Time `json:"created" xml:"created"`                                             // When the backup was taken
+} // @name Backup
+
+// Retention keeps the newest backup of each of the last Hourly hours and of
+// each of the last Daily days; the newest backup is always kept
+type Retention struct {
+	Hourly int
+	Daily  int
+}
+
+// Manager takes, lists, prunes and restores the backups stored by Target
+type Manager struct {
+	Target      Target
+	Compression string
+	Retention   Retention
+
+	// now returns the current time, replaced in tests
+	now func() time.Time
+	// mu serializes creating, pruning and restoring, whether scheduled or
+	// asked for by an admin
+	mu sync.Mutex
+}
+
+// NewManager returns a manager for the backups stored by target
+func NewManager(target Target, compression string, retention Retention) *Manager {
+	return &Manager{Target: target, Compression: compression, Retention: retention, now: time.Now}
+}
+
+// Create backs up the connected database to Target and prunes the backups
+// the retention policy no longer covers. The copy is taken with VACUUM INTO
+// and integrity checked before it is compressed and uploaded.
+func (m *Manager) Create(ctx context.Context) (Info, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	created := m.now().UTC().Truncate(time.Second)
+	name := namePrefix + created.Format(nameLayout) + nameExt + extensions[m.Compression]
+
+	tmp, err := os.MkdirTemp(tempDir(), ".backup-")
+	if err != nil {
+		return Info{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	snapshot := filepath.Join(tmp, "snapshot"+nameExt)
+	if err := database.VacuumInto(ctx, snapshot); err != nil {
+		return Info{}, fmt.Errorf("copying database: %w", err)
+	}
+	if err := Check(ctx, snapshot); err != nil {
+		return Info{}, err
+	}
+
+	compressed := filepath.Join(tmp, name)
+	sum, err := compressFile(snapshot, compressed, m.Compression)
+	if err != nil {
+		return Info{}, fmt.Errorf("compressing backup: %w", err)
+	}
+	size, err := upload(ctx, m.Target, name, compressed, sum)
+	if err != nil {
+		return Info{}, fmt.Errorf("uploading backup: %w", err)
+	}
+
+	info := Info{Name: name, Size: size, Created: created}
+	slog.InfoContext(ctx, "Backup created", "name", info.Name, "size", info.Size, "target", m.Target.String())
+
+	if _, err := m.prune(ctx); err != nil {
+		return info, fmt.Errorf("pruning backups: %w", err)
+	}
+	return info, nil
+}
+
+// Run creates a backup every interval until ctx is done
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := m.Create(ctx); err != nil {
+				slog.ErrorContext(ctx, "Scheduled backup failed", "error", err)
+			}
+		}
+	}
+}
+
+// List returns the backups stored by Target, newest first
+func (m *Manager) List(ctx context.Context) ([]Info, error) {
+	objects, err := m.Target.List(ctx, namePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]Info, 0, len(objects))
+	for _, object := range objects {
+		if created, ok := parseName(object.Name); ok {
+			backups = append(backups, Info{Name: object.Name, Size: object.Size, Created: created})
+		}
+	}
+
+	slices.SortFunc(backups, func(a, b Info) int {
+		return b.Created.Compare(a.Created)
+	})
+	return backups, nil
+}
+
+// Prune deletes the backups outside the retention policy and returns their
+// names
+func (m *Manager) Prune(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.prune(ctx)
+}
+
+func (m *Manager) prune(ctx context.Context) ([]string, error) {
+	backups, err := m.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []string
+	for _, b := range m.Retention.expired(backups, m.now()) {
+		if err := m.Target.Delete(ctx, b.Name); err != nil {
+			return pruned, err
+		}
+		slog.InfoContext(ctx, "Backup pruned", "name", b.Name)
+		pruned = append(pruned, b.Name)
+	}
+	return pruned, nil
+}
+
+// expired returns the backups, sorted newest first, that r doesn't keep
+func (r Retention) expired(backups []Info, now time.Time) []Info {
+	hours := make(map[time.Time]bool)
+	days := make(map[time.Time]bool)
+	hourlySince := now.Truncate(time.Hour).Add(-time.Duration(r.Hourly-1) * time.Hour)
+	dailySince := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, -(r.Daily - 1))
+
+	var expired []Info
+	for i, b := range backups {
+		hour := b.Created.Truncate(time.Hour)
+		day := b.Created.UTC().Truncate(24 * time.Hour)
+
+		keep := i == 0
+		if r.Hourly > 0 && !hour.Before(hourlySince) && !hours[hour] {
+			hours[hour] = true
+			keep = true
+		}
+		if r.Daily > 0 && !day.Before(dailySince) && !days[day] {
+			days[day] = true
+			keep = true
+		}
+		if !keep {
+			expired = append(expired, b)
+		}
+	}
+	return expired
+}
+
+// Restore replaces the contents of the connected database with the named
+// backup, after checking it against the checksum stored by Target
+func (m *Manager) Restore(ctx context.Context, name string) error {
+	if _, ok := parseName(name); !ok || strings.Contains(name, "/") {
+		return ErrNotFound
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tmp, err := os.MkdirTemp(tempDir(), ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	path := filepath.Join(tmp, name)
+	if err := download(ctx, m.Target, name, path); err != nil {
+		return err
+	}
+	return RestoreFile(ctx, path)
+}
+
+// RestoreFile replaces the contents of the connected database with the
+// backup file at path, which may be compressed. The backup is decompressed
+// next to the database and integrity checked before anything is replaced.
+func RestoreFile(ctx context.Context, path string) error {
+	tmp, err := os.MkdirTemp(tempDir(), ".restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	snapshot := filepath.Join(tmp, "snapshot"+nameExt)
+	if err := decompressFile(path, snapshot); err != nil {
+		return fmt.Errorf("decompressing %s: %w", path, err)
+	}
+	if err := Check(ctx, snapshot); err != nil {
+		return err
+	}
+
+	if err := database.Restore(ctx, snapshot); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Backup restored", "path", path)
+	return nil
+}
+
+// Check runs PRAGMA integrity_check on the SQLite database file at path
+func Check(ctx context.Context, path string) error {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("checking integrity of %s: %w", filepath.Base(path), err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("checking integrity of %s: %w", filepath.Base(path), err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check of %s failed: %s", filepath.Base(path), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// parseName returns the time a backup was taken from its file name
+func parseName(name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, namePrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	stamp, ext, ok := strings.Cut(stamp, nameExt)
+	if !ok || compressionOf(ext) == "" {
+		return time.Time{}, false
+	}
+	created, err := time.Parse(nameLayout, stamp)
+	return created, err == nil
+}
+
+// compressionOf returns the compression of a backup file extension, empty
+// for unknown ones
+func compressionOf(ext string) string {
+	for c, e := range extensions {
+		if e == ext {
+			return c
+		}
+	}
+	return ""
+}
+
+// tempDir returns where restores are staged: next to the database, so a
+// large backup doesn't fill a small temporary file system
+func tempDir() string {
+	if path := database.FilePath(); path != "" {
+		return filepath.Dir(path)
+	}
+	return os.TempDir()
+}
+
+// compressFile writes src to dst compressed with c and returns the hex
+// encoded SHA-256 checksum of dst
+func compressFile(src, dst, c string) (sum string, err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	h := sha256.New()
+	hashed := io.MultiWriter(out, h)
+
+	var w io.WriteCloser
+	switch c {
+	case CompressionGzip:
+		w = gzip.NewWriter(hashed)
+	case CompressionZstd:
+		if w, err = zstd.NewWriter(hashed); err != nil {
+			return "", err
+		}
+	default:
+		if _, err := io.Copy(hashed, in); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		_ = w.Close()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// upload stores the file at path as name with target and returns its size
+func upload(ctx context.Context, target Target, name, path, sum string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), target.Upload(ctx, name, f, stat.Size(), sum)
+}
+
+// download writes the object name from target to path, verifying it against
+// its stored checksum
+func download(ctx context.Context, target Target, name, path string) (err error) {
+	r, sum, err := target.Download(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	_, err = io.Copy(out, newVerifyingReader(r, sum, name))
+	return err
+}
+
+// decompressFile writes the backup src to dst, decompressed according to
+// its extension
+func decompressFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r := io.Reader(in)
+	if c := compressionOf(filepath.Ext(src)); c != "" && c != CompressionNone {
+		dec, err := compression.NewReader(c, in)
+		if err != nil {
+			return err
+		}
+		defer dec.Close()
+		r = dec
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	_, err = io.Copy(out, r)
+	return err
+}
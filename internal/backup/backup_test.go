@@ -0,0 +1,179 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupDatabase(t *testing.T) {
+	require.NoError(t, database.ConnectDatabase(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { _ = database.CloseDatabase() })
+	require.NoError(t, database.InitializeDatabase())
+}
+
+func countPersons(t *testing.T) int64 {
+	count, err := database.DbGetPersonsCount(context.Background(), database.PersonFilter{})
+	require.NoError(t, err)
+	return count
+}
+
+func TestCreateAndRestore(t *testing.T) {
+	for _, c := range Compressions {
+		t.Run(c, func(t *testing.T) {
+			setupDatabase(t)
+			ctx := context.Background()
+
+			dir := t.TempDir()
+			m := NewManager(NewDirTarget(dir), c, Retention{Hourly: 24, Daily: 31})
+			info, err := m.Create(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, info.Created.Format(nameLayout), info.Name[len(namePrefix):len(namePrefix)+len(nameLayout)])
+			assert.FileExists(t, filepath.Join(dir, info.Name))
+			assert.FileExists(t, filepath.Join(dir, info.Name+checksumExt))
+
+			backups, err := m.List(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []Info{info}, backups)
+
+			_, err = database.DbDeletePerson(ctx, 1, "test")
+			require.NoError(t, err)
+			require.EqualValues(t, 2, countPersons(t))
+
+			require.NoError(t, m.Restore(ctx, info.Name))
+			assert.EqualValues(t, 3, countPersons(t), "open connections see the restored rows")
+
+			version, err := database.GetSchemaVersion(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, database.SchemaVersion, version)
+		})
+	}
+}
+
+func TestRestoreRejectsBadBackups(t *testing.T) {
+	setupDatabase(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	m := NewManager(NewDirTarget(dir), CompressionGzip, Retention{})
+
+	assert.ErrorIs(t, m.Restore(ctx, "db_backup_2026-01-01_00-00-00.sqlite.gz"), ErrNotFound)
+	assert.ErrorIs(t, m.Restore(ctx, "../test.db"), ErrNotFound)
+
+	// Not a database: nothing is replaced
+	corrupt := "db_backup_2026-01-01_00-00-00.sqlite"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, corrupt), []byte("not a database, just some bytes to fill a page"), 0o600))
+	assert.ErrorContains(t, m.Restore(ctx, corrupt), "integrity")
+	assert.EqualValues(t, 3, countPersons(t))
+
+	// A backup that changed since it was uploaded
+	info, err := m.Create(ctx)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, info.Name), []byte("tampered"), 0o600))
+	assert.ErrorIs(t, m.Restore(ctx, info.Name), ErrChecksumMismatch)
+}
+
+// exclusiveTarget fails the test when two uploads or deletes overlap
+type exclusiveTarget struct {
+	Target
+	t      *testing.T
+	active atomic.Int32
+}
+
+func (e *exclusiveTarget) enter() func() {
+	if e.active.Add(1) > 1 {
+		e.t.Error("overlapping target writes")
+	}
+	time.Sleep(10 * time.Millisecond)
+	return func() { e.active.Add(-1) }
+}
+
+func (e *exclusiveTarget) Upload(ctx context.Context, name string, r io.ReadSeeker, size int64, sum string) error {
+	defer e.enter()()
+	return e.Target.Upload(ctx, name, r, size, sum)
+}
+
+func (e *exclusiveTarget) Delete(ctx context.Context, name string) error {
+	defer e.enter()()
+	return e.Target.Delete(ctx, name)
+}
+
+func TestManagerSerializesBackups(t *testing.T) {
+	setupDatabase(t)
+	ctx := context.Background()
+
+	// Each backup is a second after the previous one, keeping only the newest
+	var seconds atomic.Int64
+	start := time.Date(2026, 10, 19, 14, 0, 0, 0, time.UTC)
+	m := NewManager(&exclusiveTarget{Target: NewDirTarget(t.TempDir()), t: t}, CompressionGzip, Retention{Hourly: 1})
+	m.now = func() time.Time { return start.Add(time.Duration(seconds.Add(1)) * time.Second) }
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := m.Create(ctx)
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := m.Prune(ctx)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	backups, err := m.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, backups, 1)
+}
+
+func TestRetention(t *testing.T) {
+	now := time.Date(2026, 10, 19, 14, 30, 0, 0, time.UTC)
+	dir := t.TempDir()
+
+	// A backup every 20 minutes over the last three days
+	var all []string
+	for at := now.Add(-72 * time.Hour); !at.After(now); at = at.Add(20 * time.Minute) {
+		name := namePrefix + at.Format(nameLayout) + nameExt + ".gz"
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+		all = append(all, name)
+	}
+	// Files that aren't backups are left alone
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o600))
+
+	m := NewManager(NewDirTarget(dir), CompressionGzip, Retention{Hourly: 6, Daily: 2})
+	m.now = func() time.Time { return now }
+
+	pruned, err := m.Prune(context.Background())
+	require.NoError(t, err)
+
+	backups, err := m.List(context.Background())
+	require.NoError(t, err)
+	var kept []string
+	for _, b := range backups {
+		kept = append(kept, b.Created.Format("2006-01-02 15:04"))
+	}
+
+	// The newest of each of the last 6 hours, plus the newest of yesterday
+	assert.Equal(t, []string{
+		"2026-10-19 14:30",
+		"2026-10-19 13:50",
+		"2026-10-19 12:50",
+		"2026-10-19 11:50",
+		"2026-10-19 10:50",
+		"2026-10-19 09:50",
+		"2026-10-18 23:50",
+	}, kept)
+	assert.Len(t, pruned, len(all)-len(kept))
+	assert.FileExists(t, filepath.Join(dir, "notes.txt"))
+}
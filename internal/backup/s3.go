@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// checksumMetadata is the object metadata key holding the SHA-256 checksum
+const checksumMetadata = "sha256"
+
+// S3Options configures an S3Target
+type S3Options struct {
+	// Endpoint is the host and optional port of the S3 API, such as
+	// s3.amazonaws.com or localhost:9000 for MinIO
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to object names
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	// Insecure talks plain HTTP, for local stand-ins
+	Insecure bool
+}
+
+// S3Target stores backups in a bucket of an S3 compatible object store, such
+// as AWS S3 or MinIO
+type S3Target struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Target returns a target storing backups in opts.Bucket. Credentials
+// default to the standard AWS environment variables and files when no keys
+// are given.
+func NewS3Target(opts S3Options) (*S3Target, error) {
+	creds := credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, "")
+	if opts.AccessKey == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		})
+	}
+
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !opts.Insecure,
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Target{client: client, bucket: opts.Bucket, prefix: opts.Prefix}, nil
+}
+
+// Upload puts the object with a Content-MD5 header the store verifies, and
+// the SHA-256 checksum as metadata
+func (t *S3Target) Upload(ctx context.Context, name string, r io.ReadSeeker, size int64, sum string) error {
+	_, err := t.client.PutObject(ctx, t.bucket, t.prefix+name, r, size, minio.PutObjectOptions{
+		ContentType:    "application/octet-stream",
+		UserMetadata:   map[string]string{checksumMetadata: sum},
+		SendContentMd5: true,
+	})
+	return err
+}
+
+// Download gets the object along with its checksum metadata
+func (t *S3Target) Download(ctx context.Context, name string) (io.ReadCloser, string, error) {
+	info, err := t.client.StatObject(ctx, t.bucket, t.prefix+name, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, "", t.wrapError(err)
+	}
+
+	obj, err := t.client.GetObject(ctx, t.bucket, t.prefix+name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", t.wrapError(err)
+	}
+	return obj, metadataValue(info.UserMetadata, checksumMetadata), nil
+}
+
+// List lists the objects below the target prefix starting with prefix
+func (t *S3Target) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	for info := range t.client.ListObjects(ctx, t.bucket, minio.ListObjectsOptions{
+		Prefix:    t.prefix + prefix,
+		Recursive: true,
+	}) {
+		if info.Err != nil {
+			return nil, t.wrapError(info.Err)
+		}
+		objects = append(objects, Object{Name: strings.TrimPrefix(info.Key, t.prefix), Size: info.Size})
+	}
+	return objects, nil
+}
+
+// Delete removes the object
+func (t *S3Target) Delete(ctx context.Context, name string) error {
+	err := t.wrapError(t.client.RemoveObject(ctx, t.bucket, t.prefix+name, minio.RemoveObjectOptions{}))
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// String describes the target in logs
+func (t *S3Target) String() string {
+	return fmt.Sprintf("s3:%s/%s/%s", t.client.EndpointURL().Host, t.bucket, t.prefix)
+}
+
+// wrapError maps missing objects to ErrNotFound
+func (t *S3Target) wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	resp := minio.ToErrorResponse(err)
+	if resp.StatusCode == http.StatusNotFound && resp.Code != "NoSuchBucket" {
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return err
+}
+
+// metadataValue looks up key in object metadata, whose keys stores and
+// clients capitalize differently
+func metadataValue(metadata map[string]string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) || strings.EqualFold(k, "X-Amz-Meta-"+key) {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,194 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/require"
+)
+
+// s3StandIn is an in-memory, path style S3 API covering the requests the
+// S3 target makes, in the spirit of a local MinIO
+type s3StandIn struct {
+	mu      sync.Mutex
+	objects map[string]storedObject
+}
+
+// storedObject is an object of a stand-in store
+type storedObject struct {
+	data     []byte
+	metadata http.Header
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != "backups" {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		s.list(w, r.URL.Query().Get("prefix"))
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err == nil && strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			data, err = decodeAWSChunked(data)
+		}
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		sum := md5.Sum(data)
+		if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			s3Error(w, http.StatusBadRequest, "BadDigest")
+			return
+		}
+		metadata := http.Header{}
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, "X-Amz-Meta-") {
+				metadata[k] = v
+			}
+		}
+		s.objects[key] = storedObject{data: data, metadata: metadata}
+		w.Header().Set("ETag", `"`+strconv.Quote(key)+`"`)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := s.objects[key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for k, v := range object.metadata {
+			w.Header()[k] = v
+		}
+		w.Header().Set("ETag", `"`+strconv.Quote(key)+`"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(object.data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *s3StandIn) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string
+		Size         int64
+		LastModified string
+	}
+	result := struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Name     string
+		Prefix   string
+		KeyCount int
+		Contents []content
+	}{Name: "backups", Prefix: prefix}
+
+	for key, object := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{
+				Key:          key,
+				Size:         int64(len(object.data)),
+				LastModified: time.Now().UTC().Format(time.RFC3339),
+			})
+		}
+	}
+	slices.SortFunc(result.Contents, func(a, b content) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// decodeAWSChunked strips the chunk framing clients use to sign a streamed
+// body over plain HTTP
+func decodeAWSChunked(body []byte) ([]byte, error) {
+	var data []byte
+	for {
+		header, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		if !ok {
+			return nil, errors.New("truncated chunk header")
+		}
+		hexSize, _, _ := bytes.Cut(header, []byte(";"))
+		size, err := strconv.ParseInt(string(hexSize), 16, 64)
+		if err != nil || int64(len(rest)) < size {
+			return nil, errors.New("bad chunk size")
+		}
+		if size == 0 {
+			return data, nil
+		}
+		data = append(data, rest[:size]...)
+		body = bytes.TrimPrefix(rest[size:], []byte("\r\n"))
+	}
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+	}{Code: code})
+}
+
+// TestS3Target runs against an in-memory stand-in, or the MinIO server
+// given by S3_TEST_ENDPOINT, S3_TEST_ACCESS_KEY and S3_TEST_SECRET_KEY
+func TestS3Target(t *testing.T) {
+	opts := S3Options{
+		Endpoint:  os.Getenv("S3_TEST_ENDPOINT"),
+		Region:    "us-east-1",
+		Bucket:    "backups",
+		Prefix:    "test/",
+		AccessKey: os.Getenv("S3_TEST_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_TEST_SECRET_KEY"),
+		Insecure:  true,
+	}
+	if opts.Endpoint == "" {
+		server := httptest.NewServer(&s3StandIn{objects: make(map[string]storedObject)})
+		t.Cleanup(server.Close)
+		opts.Endpoint = strings.TrimPrefix(server.URL, "http://")
+		opts.AccessKey, opts.SecretKey = "minioadmin", "minioadmin"
+	}
+
+	target, err := NewS3Target(opts)
+	require.NoError(t, err)
+	if os.Getenv("S3_TEST_ENDPOINT") != "" {
+		ctx := context.Background()
+		if exists, err := target.client.BucketExists(ctx, opts.Bucket); err == nil && !exists {
+			require.NoError(t, target.client.MakeBucket(ctx, opts.Bucket, minio.MakeBucketOptions{}))
+		}
+		t.Cleanup(func() {
+			objects, _ := target.List(ctx, "")
+			for _, o := range objects {
+				_ = target.Delete(ctx, o.Name)
+			}
+		})
+	}
+
+	testTarget(t, target)
+}
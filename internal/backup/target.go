@@ -0,0 +1,235 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Target stores backup files under flat, "/" separated names. Downloading
+// an object that doesn't exist fails with ErrNotFound, deleting one is not
+// an error.
+type Target interface {
+	// Upload stores size bytes read from r as name. sum is their hex encoded
+	// SHA-256 checksum, stored along with the object and handed back by
+	// Download; targets check the upload against it or their own checksum.
+	Upload(ctx context.Context, name string, r io.ReadSeeker, size int64, sum string) error
+	// Download opens the object name and returns its stored SHA-256
+	// checksum, empty when it has none
+	Download(ctx context.Context, name string) (io.ReadCloser, string, error)
+	// List returns the objects whose names start with prefix, sorted by name
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// Delete removes the object name
+	Delete(ctx context.Context, name string) error
+	// String describes the target in logs
+	String() string
+}
+
+// Object is a file stored by a Target
+type Object struct {
+	Name string
+	Size int64
+}
+
+// ErrChecksumMismatch is returned when stored data doesn't match its checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// checksumExt is appended to the name of a file to get its checksum file,
+// written in the format of sha256sum
+const checksumExt = ".sha256"
+
+// DirTarget stores backups in a local directory, each next to a checksum file
+type DirTarget struct {
+	Dir string
+}
+
+// NewDirTarget returns a target storing backups in dir
+func NewDirTarget(dir string) *DirTarget {
+	return &DirTarget{Dir: dir}
+}
+
+// Upload copies r into the directory, failing if it doesn't match sum
+func (t *DirTarget) Upload(ctx context.Context, name string, r io.ReadSeeker, size int64, sum string) (err error) {
+	path, err := t.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("uploading %s: %w: got %s, expected %s", name, ErrChecksumMismatch, got, sum)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// The checksum goes first, so a listed backup always has one
+	line := sum + "  " + filepath.Base(name) + "\n"
+	if err := os.WriteFile(path+checksumExt, []byte(line), 0o640); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Download opens the file name along with the checksum recorded next to it
+func (t *DirTarget) Download(ctx context.Context, name string) (io.ReadCloser, string, error) {
+	path, err := t.path(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	sum, err := readChecksum(path + checksumExt)
+	if err != nil {
+		_ = f.Close()
+		return nil, "", err
+	}
+	return f, sum, nil
+}
+
+// List walks the directory for files starting with prefix, leaving out
+// checksum and partially uploaded files
+func (t *DirTarget) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	err := filepath.WalkDir(t.Dir, func(path string, d os.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) && path == t.Dir {
+			return filepath.SkipAll
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		name, err := filepath.Rel(t.Dir, path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if !strings.HasPrefix(name, prefix) || strings.HasSuffix(name, checksumExt) || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Name: name, Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(objects, func(a, b Object) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return objects, nil
+}
+
+// Delete removes the file name and its checksum file
+func (t *DirTarget) Delete(ctx context.Context, name string) error {
+	path, err := t.path(name)
+	if err != nil {
+		return err
+	}
+	for _, p := range []string{path, path + checksumExt} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// String describes the target in logs
+func (t *DirTarget) String() string {
+	return "dir:" + t.Dir
+}
+
+// path maps an object name to a file path, rejecting names escaping Dir
+func (t *DirTarget) path(name string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("invalid backup name %q", name)
+	}
+	return filepath.Join(t.Dir, filepath.FromSlash(name)), nil
+}
+
+// readChecksum reads a sha256sum formatted file, returning an empty checksum
+// when there is none
+func readChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	sum, _, _ := strings.Cut(line, " ")
+	return strings.TrimSpace(sum), nil
+}
+
+// verifyingReader hashes what is read through it and fails at the end of
+// the stream when the data doesn't match the expected checksum
+type verifyingReader struct {
+	r        io.Reader
+	h        hash.Hash
+	expected string
+	name     string
+}
+
+// newVerifyingReader checks what is read from r against sum, unless sum is
+// empty
+func newVerifyingReader(r io.Reader, sum, name string) io.Reader {
+	if sum == "" {
+		return r
+	}
+	return &verifyingReader{r: r, h: sha256.New(), expected: sum, name: name}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		if got := hex.EncodeToString(v.h.Sum(nil)); got != v.expected {
+			return n, fmt.Errorf("downloading %s: %w: got %s, expected %s", v.name, ErrChecksumMismatch, got, v.expected)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// testTarget runs the behaviour every Target shares, then backs up and
+// restores the database through it
+func testTarget(t *testing.T, target Target) {
+	ctx := context.Background()
+
+	data := []byte("not really a backup")
+	for _, name := range []string{"db_backup_a.sqlite.gz", "db_backup_b.sqlite.gz", "wal/0001"} {
+		require.NoError(t, target.Upload(ctx, name, bytes.NewReader(data), int64(len(data)), checksum(data)))
+	}
+
+	objects, err := target.List(ctx, "db_backup_")
+	require.NoError(t, err)
+	assert.Equal(t, []Object{
+		{Name: "db_backup_a.sqlite.gz", Size: int64(len(data))},
+		{Name: "db_backup_b.sqlite.gz", Size: int64(len(data))},
+	}, objects)
+
+	r, sum, err := target.Download(ctx, "wal/0001")
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, data, got)
+	assert.Equal(t, checksum(data), sum)
+
+	_, _, err = target.Download(ctx, "db_backup_missing.sqlite.gz")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, target.Delete(ctx, "db_backup_a.sqlite.gz"))
+	require.NoError(t, target.Delete(ctx, "db_backup_a.sqlite.gz"), "deleting twice")
+	objects, err = target.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []Object{
+		{Name: "db_backup_b.sqlite.gz", Size: int64(len(data))},
+		{Name: "wal/0001", Size: int64(len(data))},
+	}, objects)
+
+	// A full round trip through the target
+	setupDatabase(t)
+	m := NewManager(target, CompressionZstd, Retention{Hourly: 1})
+	info, err := m.Create(ctx)
+	require.NoError(t, err)
+
+	backups, err := m.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, backups, 1, "names without a timestamp are not backups")
+	assert.Equal(t, info, backups[0])
+
+	_, err = database.DbDeletePerson(ctx, 2, "test")
+	require.NoError(t, err)
+	require.NoError(t, m.Restore(ctx, info.Name))
+	assert.EqualValues(t, 3, countPersons(t))
+}
+
+func TestDirTarget(t *testing.T) {
+	dir := t.TempDir()
+	testTarget(t, NewDirTarget(dir))
+
+	sum, err := os.ReadFile(filepath.Join(dir, "wal", "0001"+checksumExt))
+	require.NoError(t, err)
+	assert.Equal(t, checksum([]byte("not really a backup"))+"  0001\n", string(sum), "sha256sum format")
+
+	target := NewDirTarget(dir)
+	err = target.Upload(context.Background(), "corrupt", bytes.NewReader([]byte("abc")), 3, checksum([]byte("abd")))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.NoFileExists(t, filepath.Join(dir, "corrupt"))
+
+	err = target.Upload(context.Background(), "../escape", bytes.NewReader(nil), 0, checksum(nil))
+	assert.ErrorContains(t, err, "invalid backup name")
+}
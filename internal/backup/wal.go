@@ -0,0 +1,742 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/database"
+)
+
+// The WAL file format, see https://www.sqlite.org/fileformat2.html#walformat
+const (
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+	walMagic           = 0x377f0682
+)
+
+// The replica is stored as wal/<generation>/snapshot.sqlite[.gz|.zst]
+// followed by wal/<generation>/<index>_<time>.wal[.gz|.zst] segments, where
+// generations are named after the time they started
+const (
+	walPrefix    = "wal/"
+	walLayout    = "2006-01-02_15-04-05.000000000"
+	snapshotName = "snapshot" + nameExt
+	segmentExt   = ".wal"
+)
+
+// checkpointFrames is the WAL size from which a sync checkpoints, SQLite's
+// default for automatic checkpoints
+const checkpointFrames = 1000
+
+// errEmptyWAL is returned when reading the header of an empty WAL
+var errEmptyWAL = errors.New("empty WAL")
+
+// ReplicaDataSource returns the data source to open the database file at
+// path with for WAL replication: without automatic checkpoints, which the
+// Replicator takes over
+func ReplicaDataSource(path string) string {
+	return path + "?_pragma=wal_autocheckpoint(0)"
+}
+
+// Replicator ships the write-ahead log of the connected database to Target
+// for point-in-time recovery, in the manner of Litestream. The replica is a
+// series of generations, each a snapshot of the database followed by
+// segments holding the WAL frames of the transactions committed between two
+// syncs.
+//
+// Frames the WAL drops before they were shipped are lost to the replica, so
+// the Replicator does the checkpointing itself and only lets the WAL restart
+// once everything in it was shipped. A restart it didn't expect, such as
+// after another process checkpointed the database, starts a new generation.
+type Replicator struct {
+	Target      Target
+	Compression string
+	// Retention is how far back restores reach; a new generation starts
+	// once the current one is this old
+	Retention time.Duration
+
+	// checkpointFrames is the WAL size from which a sync checkpoints,
+	// replaced in tests
+	checkpointFrames int64
+	// now returns the current time, replaced in tests
+	now func() time.Time
+
+	mu         sync.Mutex
+	db         *sql.DB
+	conn       *sql.Conn
+	dbPath     string
+	generation string
+	started    time.Time
+	index      int
+	pos        walPosition
+}
+
+// walPosition is how far the replica caught up with the WAL
+type walPosition struct {
+	salt1, salt2 uint32
+	// offset is the end of the last shipped commit frame, 0 when the next
+	// WAL header is yet to be seen
+	offset int64
+	// sum1 and sum2 are the cumulative frame checksum at offset
+	sum1, sum2 uint32
+	// checkpointed is set when all frames up to offset were copied into the
+	// database file, so the next writer may restart the WAL
+	checkpointed bool
+}
+
+// walHeader is the header of a WAL file
+type walHeader struct {
+	raw          []byte
+	pageSize     int64
+	salt1, salt2 uint32
+	sum1, sum2   uint32
+	bigEndian    bool
+}
+
+// start returns the position of the first frame after h
+func (h walHeader) start() walPosition {
+	return walPosition{salt1: h.salt1, salt2: h.salt2, offset: walHeaderSize, sum1: h.sum1, sum2: h.sum2}
+}
+
+// frames returns the number of frames before pos
+func (h walHeader) frames(pos walPosition) int64 {
+	return (pos.offset - walHeaderSize) / (walFrameHeaderSize + h.pageSize)
+}
+
+// NewReplicator returns a replicator shipping the WAL to target
+func NewReplicator(target Target, compression string, retention time.Duration) *Replicator {
+	return &Replicator{
+		Target:           target,
+		Compression:      compression,
+		Retention:        retention,
+		checkpointFrames: checkpointFrames,
+		now:              time.Now,
+	}
+}
+
+// Start opens connections of its own, so syncs don't queue behind the
+// writer, one of them dedicated to holding the WAL still while syncing,
+// then begins a new generation. The database must have been opened with
+// ReplicaDataSource.
+func (r *Replicator) Start(ctx context.Context) error {
+	path := database.FilePath()
+	if path == "" {
+		return errors.New("WAL replication needs a database file")
+	}
+
+	db, err := database.Open()
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		_ = db.Close()
+		return err
+	}
+	var mode string
+	var autocheckpoint int
+	err = conn.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&mode)
+	if err == nil {
+		err = conn.QueryRowContext(ctx, "PRAGMA wal_autocheckpoint").Scan(&autocheckpoint)
+	}
+	if err == nil && (mode != "wal" || autocheckpoint != 0) {
+		err = fmt.Errorf("WAL replication needs the database in WAL mode without automatic checkpoints, got %s mode checkpointing every %d pages", mode, autocheckpoint)
+	}
+	if err != nil {
+		_ = conn.Close()
+		_ = db.Close()
+		return err
+	}
+
+	r.mu.Lock()
+	r.db, r.conn, r.dbPath = db, conn, path
+	r.mu.Unlock()
+	return r.Sync(ctx)
+}
+
+// Run syncs every interval until ctx is done
+func (r *Replicator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Sync(ctx); err != nil {
+				slog.ErrorContext(ctx, "WAL sync failed", "error", err)
+			}
+		}
+	}
+}
+
+// Close ships the transactions committed since the last sync and releases
+// the connection
+func (r *Replicator) Close(ctx context.Context) error {
+	err := r.Sync(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn != nil {
+		err = errors.Join(err, r.conn.Close(), r.db.Close())
+		r.conn, r.db = nil, nil
+	}
+	return err
+}
+
+// shipment is an object a sync uploads once it released the WAL, along
+// with the replication state to move to after uploading it
+type shipment struct {
+	name       string
+	path       string
+	generation string
+	started    time.Time
+	index      int
+	pos        walPosition
+}
+
+// Sync ships the transactions committed since the last sync as a segment,
+// or a snapshot when a new generation starts. Writers wait while the WAL is
+// read, but not for the upload.
+func (r *Replicator) Sync(ctx context.Context) error {
+	return r.sync(ctx, r.checkpointFrames)
+}
+
+// Checkpoint syncs and copies all of the WAL into the database file however
+// small it is, so the next write restarts it
+func (r *Replicator) Checkpoint(ctx context.Context) error {
+	return r.sync(ctx, 1)
+}
+
+// sync ships the WAL like Sync, checkpointing from minFrames frames
+func (r *Replicator) sync(ctx context.Context, minFrames int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return errors.New("WAL replication is not running")
+	}
+
+	tmp, err := os.MkdirTemp(tempDir(), ".wal-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	// Holding the write lock, the WAL can neither grow nor restart
+	if _, err := r.conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return err
+	}
+	s, err := r.read(ctx, tmp, minFrames)
+	// Nothing was written; the connection must not stay in the transaction
+	// even when ctx is done
+	if _, rollbackErr := r.conn.ExecContext(context.WithoutCancel(ctx), "ROLLBACK"); err == nil {
+		err = rollbackErr
+	}
+	if err != nil || s == nil {
+		return err
+	}
+
+	compressed := filepath.Join(tmp, filepath.Base(s.name))
+	sum, err := compressFile(s.path, compressed, r.Compression)
+	if err != nil {
+		return err
+	}
+	if _, err := upload(ctx, r.Target, s.name, compressed, sum); err != nil {
+		return fmt.Errorf("uploading %s: %w", s.name, err)
+	}
+
+	r.generation, r.started, r.index, r.pos = s.generation, s.started, s.index, s.pos
+	if s.index > 0 {
+		return nil
+	}
+	slog.InfoContext(ctx, "WAL replication generation started", "generation", s.generation, "target", r.Target.String())
+	if err := r.prune(ctx); err != nil {
+		return fmt.Errorf("pruning WAL generations: %w", err)
+	}
+	return nil
+}
+
+// read returns what the WAL holds beyond the replica, or nil when the
+// replica is up to date; the write lock must be held
+func (r *Replicator) read(ctx context.Context, tmp string, minFrames int64) (*shipment, error) {
+	now := r.now().UTC()
+
+	f, err := os.Open(r.dbPath + "-wal")
+	if errors.Is(err, fs.ErrNotExist) {
+		f, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hdr, err := readWALHeader(f)
+	empty := errors.Is(err, errEmptyWAL)
+	if err != nil && !empty {
+		return nil, err
+	}
+
+	pos := r.pos
+	switch {
+	case r.generation == "" || now.Sub(r.started) >= r.Retention:
+		return r.snapshot(f, hdr, empty, now, tmp)
+	case empty && pos.offset > walHeaderSize && !pos.checkpointed:
+		slog.WarnContext(ctx, "WAL was truncated before it was shipped, starting a new generation")
+		return r.snapshot(f, hdr, empty, now, tmp)
+	case empty:
+		r.pos = walPosition{}
+		return nil, nil
+	case hdr.salt1 != pos.salt1 || hdr.salt2 != pos.salt2:
+		// Every restart increments salt1
+		if pos.offset != 0 && !(pos.checkpointed && hdr.salt1 == pos.salt1+1) {
+			slog.WarnContext(ctx, "WAL restarted before it was shipped, starting a new generation")
+			return r.snapshot(f, hdr, empty, now, tmp)
+		}
+		pos = hdr.start()
+	}
+
+	end, err := scanWAL(f, hdr, pos)
+	if err != nil {
+		return nil, err
+	}
+	if end.checkpointed, err = r.checkpoint(ctx, hdr, end, minFrames); err != nil {
+		return nil, err
+	}
+	if end.offset == pos.offset {
+		r.pos = end
+		return nil, nil
+	}
+
+	raw := filepath.Join(tmp, "segment"+segmentExt)
+	out, err := os.OpenFile(raw, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	_, err = out.Write(hdr.raw)
+	if err == nil {
+		_, err = io.Copy(out, io.NewSectionReader(f, pos.offset, end.offset-pos.offset))
+	}
+	if err := errors.Join(err, out.Close()); err != nil {
+		return nil, err
+	}
+
+	index := r.index + 1
+	return &shipment{
+		name:       fmt.Sprintf("%s%s/%08d_%s%s%s", walPrefix, r.generation, index, now.Format(walLayout), segmentExt, extensions[r.Compression]),
+		path:       raw,
+		generation: r.generation,
+		started:    r.started,
+		index:      index,
+		pos:        end,
+	}, nil
+}
+
+// snapshot copies the database file and applies the committed frames of the
+// WAL to the copy, starting a new generation; the write lock must be held
+func (r *Replicator) snapshot(wal *os.File, hdr walHeader, empty bool, now time.Time, tmp string) (*shipment, error) {
+	path := filepath.Join(tmp, snapshotName)
+	if err := copyFile(r.dbPath, path); err != nil {
+		return nil, err
+	}
+
+	var pos walPosition
+	if !empty {
+		end, err := scanWAL(wal, hdr, hdr.start())
+		if err != nil {
+			return nil, err
+		}
+		frames := io.NewSectionReader(wal, walHeaderSize, end.offset-walHeaderSize)
+		if err := applyFrames(path, frames, hdr.pageSize); err != nil {
+			return nil, err
+		}
+		pos = end
+	}
+
+	generation := now.Format(walLayout)
+	return &shipment{
+		name:       walPrefix + generation + "/" + snapshotName + extensions[r.Compression],
+		path:       path,
+		generation: generation,
+		started:    now,
+		pos:        pos,
+	}, nil
+}
+
+// checkpoint copies the WAL into the database file once it reached
+// minFrames, and reports whether all of it up to end was copied, so
+// the WAL restarts with everything in it shipped. It checkpoints on another
+// of its connections while the write lock is held, so no frames are added
+// meanwhile.
+func (r *Replicator) checkpoint(ctx context.Context, hdr walHeader, end walPosition, minFrames int64) (bool, error) {
+	frames := hdr.frames(end)
+	if frames < minFrames || end.checkpointed {
+		return end.checkpointed, nil
+	}
+
+	var busy, log, checkpointed int64
+	err := r.db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(PASSIVE)").Scan(&busy, &log, &checkpointed)
+	if err != nil {
+		return false, fmt.Errorf("checkpointing: %w", err)
+	}
+	return log == frames && checkpointed == frames, nil
+}
+
+// prune deletes the generations only needed to restore times before the
+// retention period; the current generation is always kept
+func (r *Replicator) prune(ctx context.Context) error {
+	generations, err := r.generations(ctx)
+	if err != nil {
+		return err
+	}
+
+	horizon := r.now().Add(-r.Retention)
+	for i, g := range generations {
+		if g.name == r.generation || i+1 == len(generations) || !generations[i+1].started.Before(horizon) {
+			break
+		}
+		for _, name := range g.objects {
+			if err := r.Target.Delete(ctx, name); err != nil {
+				return err
+			}
+		}
+		slog.InfoContext(ctx, "WAL replication generation pruned", "generation", g.name)
+	}
+	return nil
+}
+
+// walGeneration is a generation stored by the Target
+type walGeneration struct {
+	name     string
+	started  time.Time
+	snapshot string
+	segments []walSegment
+	// objects lists the names of every object of the generation
+	objects []string
+}
+
+// walSegment is a segment of a generation
+type walSegment struct {
+	name  string
+	index int
+	time  time.Time
+}
+
+// generations returns the generations stored by Target, oldest first
+func (r *Replicator) generations(ctx context.Context) ([]walGeneration, error) {
+	objects, err := r.Target.List(ctx, walPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var generations []walGeneration
+	for _, object := range objects {
+		name, file, ok := strings.Cut(strings.TrimPrefix(object.Name, walPrefix), "/")
+		if !ok {
+			continue
+		}
+		started, err := time.Parse(walLayout, name)
+		if err != nil {
+			continue
+		}
+		if len(generations) == 0 || generations[len(generations)-1].name != name {
+			generations = append(generations, walGeneration{name: name, started: started})
+		}
+		g := &generations[len(generations)-1]
+		g.objects = append(g.objects, object.Name)
+
+		if ext, ok := strings.CutPrefix(file, snapshotName); ok && compressionOf(ext) != "" {
+			g.snapshot = object.Name
+		} else if s, ok := parseSegment(file); ok {
+			s.name = object.Name
+			g.segments = append(g.segments, s)
+		}
+	}
+	return generations, nil
+}
+
+// parseSegment returns the index and time of a segment from its file name
+func parseSegment(file string) (walSegment, bool) {
+	index, rest, ok := strings.Cut(file, "_")
+	if !ok {
+		return walSegment{}, false
+	}
+	stamp, ext, ok := strings.Cut(rest, segmentExt)
+	if !ok || compressionOf(ext) == "" {
+		return walSegment{}, false
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return walSegment{}, false
+	}
+	t, err := time.Parse(walLayout, stamp)
+	return walSegment{index: i, time: t}, err == nil
+}
+
+// segment returns the segment at index to apply for a restore to at. A
+// sync retried after a failed upload ships the same index again, up to a
+// later time, so the latest one not after at is picked.
+func (g walGeneration) segment(index int, at time.Time) (walSegment, bool) {
+	var found walSegment
+	for _, s := range g.segments {
+		if s.index == index && !s.time.After(at) && s.time.After(found.time) {
+			found = s
+		}
+	}
+	return found, found.name != ""
+}
+
+// Rebuild writes the database as it was at the given time to path, which
+// must not exist yet, and returns the time of the last sync it includes
+func (r *Replicator) Rebuild(ctx context.Context, at time.Time, path string) (time.Time, error) {
+	generations, err := r.generations(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	i := len(generations) - 1
+	for i >= 0 && (generations[i].snapshot == "" || generations[i].started.After(at)) {
+		i--
+	}
+	if i < 0 {
+		return time.Time{}, fmt.Errorf("%w: no WAL replica from before %s", ErrNotFound, at.Format(time.RFC3339))
+	}
+	g := generations[i]
+
+	tmp, err := os.MkdirTemp(tempDir(), ".download-")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := r.fetch(ctx, g.snapshot, tmp, path); err != nil {
+		return time.Time{}, err
+	}
+
+	restored := g.started
+	for index := 1; ; index++ {
+		s, ok := g.segment(index, at)
+		if !ok {
+			if slices.ContainsFunc(g.segments, func(s walSegment) bool { return s.index > index && !s.time.After(at) }) {
+				return time.Time{}, fmt.Errorf("segment %d of WAL replica generation %s is missing", index, g.name)
+			}
+			break
+		}
+
+		segment := filepath.Join(tmp, fmt.Sprintf("segment-%d%s", index, segmentExt))
+		if err := r.fetch(ctx, s.name, tmp, segment); err != nil {
+			return time.Time{}, err
+		}
+		if err := applySegment(path, segment); err != nil {
+			return time.Time{}, fmt.Errorf("applying %s: %w", s.name, err)
+		}
+		restored = s.time
+	}
+
+	// Open the result in rollback journal mode, which unlike WAL mode
+	// doesn't need write access to read it
+	return restored, setRollbackMode(path)
+}
+
+// Restore replaces the contents of the connected database with its state at
+// the given time, after checking its integrity, and returns the time of the
+// last sync restored
+func (r *Replicator) Restore(ctx context.Context, at time.Time) (time.Time, error) {
+	tmp, err := os.MkdirTemp(tempDir(), ".restore-")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	path := filepath.Join(tmp, snapshotName)
+	restored, err := r.Rebuild(ctx, at, path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := Check(ctx, path); err != nil {
+		return time.Time{}, err
+	}
+	if err := database.Restore(ctx, path); err != nil {
+		return time.Time{}, err
+	}
+	slog.InfoContext(ctx, "Point-in-time restore completed", "at", at, "restored", restored)
+	return restored, nil
+}
+
+// fetch downloads the object name into dir and decompresses it to path
+func (r *Replicator) fetch(ctx context.Context, name, dir, path string) error {
+	downloaded := filepath.Join(dir, filepath.Base(name))
+	if err := download(ctx, r.Target, name, downloaded); err != nil {
+		return err
+	}
+	defer os.Remove(downloaded)
+	return decompressFile(downloaded, path)
+}
+
+// readWALHeader reads and verifies the header of a WAL file, which may be
+// nil when there is none
+func readWALHeader(f *os.File) (walHeader, error) {
+	raw := make([]byte, walHeaderSize)
+	if f == nil {
+		return walHeader{}, errEmptyWAL
+	}
+	if _, err := f.ReadAt(raw, 0); errors.Is(err, io.EOF) {
+		return walHeader{}, errEmptyWAL
+	} else if err != nil {
+		return walHeader{}, err
+	}
+
+	magic := binary.BigEndian.Uint32(raw)
+	if magic&^1 != walMagic {
+		return walHeader{}, errors.New("not a WAL file")
+	}
+	h := walHeader{
+		raw:       raw,
+		pageSize:  int64(binary.BigEndian.Uint32(raw[8:])),
+		salt1:     binary.BigEndian.Uint32(raw[16:]),
+		salt2:     binary.BigEndian.Uint32(raw[20:]),
+		bigEndian: magic&1 == 1,
+	}
+	h.sum1, h.sum2 = walChecksum(h.bigEndian, 0, 0, raw[:24])
+	if h.sum1 != binary.BigEndian.Uint32(raw[24:]) || h.sum2 != binary.BigEndian.Uint32(raw[28:]) {
+		return walHeader{}, errors.New("WAL header checksum mismatch")
+	}
+	return h, nil
+}
+
+// scanWAL returns the position after the last valid commit frame following
+// from. Frames left over from before the WAL restarted carry other salts,
+// and a torn write fails the checksum.
+func scanWAL(f *os.File, hdr walHeader, from walPosition) (walPosition, error) {
+	end := from
+	frame := make([]byte, walFrameHeaderSize+hdr.pageSize)
+	sum1, sum2 := from.sum1, from.sum2
+	for offset := from.offset; ; offset += int64(len(frame)) {
+		if _, err := f.ReadAt(frame, offset); errors.Is(err, io.EOF) {
+			return end, nil
+		} else if err != nil {
+			return walPosition{}, err
+		}
+		if binary.BigEndian.Uint32(frame[8:]) != hdr.salt1 || binary.BigEndian.Uint32(frame[12:]) != hdr.salt2 {
+			return end, nil
+		}
+		sum1, sum2 = walChecksum(hdr.bigEndian, sum1, sum2, frame[:8])
+		sum1, sum2 = walChecksum(hdr.bigEndian, sum1, sum2, frame[walFrameHeaderSize:])
+		if sum1 != binary.BigEndian.Uint32(frame[16:]) || sum2 != binary.BigEndian.Uint32(frame[20:]) {
+			return end, nil
+		}
+		// The database size in pages is only set on commit frames
+		if binary.BigEndian.Uint32(frame[4:]) != 0 {
+			end.offset, end.sum1, end.sum2 = offset+int64(len(frame)), sum1, sum2
+			end.checkpointed = false
+		}
+	}
+}
+
+// walChecksum continues the cumulative WAL checksum s1, s2 over b
+func walChecksum(bigEndian bool, s1, s2 uint32, b []byte) (uint32, uint32) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	for i := 0; i+8 <= len(b); i += 8 {
+		s1 += order.Uint32(b[i:]) + s2
+		s2 += order.Uint32(b[i+4:]) + s1
+	}
+	return s1, s2
+}
+
+// applySegment applies the frames of the segment file at path to the
+// database file at db
+func applySegment(db, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	hdr := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return err
+	}
+	return applyFrames(db, r, int64(binary.BigEndian.Uint32(hdr[8:])))
+}
+
+// applyFrames writes the pages of the WAL frames read from frames to the
+// database file at path, as a checkpoint does
+func applyFrames(path string, frames io.Reader, pageSize int64) (err error) {
+	db, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := db.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	frame := make([]byte, walFrameHeaderSize+pageSize)
+	for {
+		if _, err := io.ReadFull(frames, frame); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		page := int64(binary.BigEndian.Uint32(frame))
+		if _, err := db.WriteAt(frame[walFrameHeaderSize:], (page-1)*pageSize); err != nil {
+			return err
+		}
+		if size := int64(binary.BigEndian.Uint32(frame[4:])); size != 0 {
+			if err := db.Truncate(size * pageSize); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// setRollbackMode sets the file format version bytes of the database header
+// at path to the legacy, rollback journal, format
+func setRollbackMode(path string) error {
+	db, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	_, err = db.WriteAt([]byte{1, 1}, 18)
+	return errors.Join(err, db.Close())
+}
+
+// copyFile copies the file at src to dst, which must not exist yet
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	_, err = io.Copy(out, in)
+	return err
+}
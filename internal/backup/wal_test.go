@@ -0,0 +1,158 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupReplica connects a database for replication and returns a started
+// replicator along with its clock, which ticks a second per reading
+func setupReplica(t *testing.T) (*Replicator, *time.Time) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	require.NoError(t, database.ConnectDatabase(ReplicaDataSource(path)))
+	t.Cleanup(func() { _ = database.CloseDatabase() })
+	require.NoError(t, database.InitializeDatabase())
+
+	clock := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	r := NewReplicator(NewDirTarget(t.TempDir()), CompressionZstd, 24*time.Hour)
+	r.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	require.NoError(t, r.Start(context.Background()))
+	t.Cleanup(func() { _ = r.Close(context.Background()) })
+	return r, &clock
+}
+
+func exec(t *testing.T, query string, args ...any) {
+	_, err := database.DB.Exec(query, args...)
+	require.NoError(t, err)
+}
+
+// persons returns every row of the people table of db
+func persons(t *testing.T, db *sql.DB) []models.Person {
+	rows, err := db.Query("SELECT id, first_name, last_name, email FROM people ORDER BY id")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var people []models.Person
+	for rows.Next() {
+		var p models.Person
+		require.NoError(t, rows.Scan(&p.ID, &p.FirstName, &p.LastName, &p.Email))
+		people = append(people, p)
+	}
+	require.NoError(t, rows.Err())
+	return people
+}
+
+// rebuiltPersons rebuilds the database as it was at the given time and
+// returns its people
+func rebuiltPersons(t *testing.T, r *Replicator, at time.Time) []models.Person {
+	path := filepath.Join(t.TempDir(), "rebuilt.db")
+	_, err := r.Rebuild(context.Background(), at, path)
+	require.NoError(t, err)
+	require.NoError(t, Check(context.Background(), path))
+
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	require.NoError(t, err)
+	defer db.Close()
+	return persons(t, db)
+}
+
+func TestReplicateAndRestore(t *testing.T) {
+	r, _ := setupReplica(t)
+	ctx := context.Background()
+
+	type state struct {
+		at     time.Time
+		people []models.Person
+	}
+	var states []state
+	sync := func() {
+		require.NoError(t, r.Sync(ctx))
+		states = append(states, state{at: r.now(), people: persons(t, database.DB)})
+	}
+
+	sync()
+	exec(t, "INSERT INTO people (first_name, last_name, email) VALUES ('Alice', 'Liddell', 'alice@example.com')")
+	sync()
+	exec(t, "UPDATE people SET email = 'jane@example.com' WHERE first_name = 'Jane'")
+	exec(t, "DELETE FROM people WHERE first_name = 'Bob'")
+	sync()
+	exec(t, `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 500)
+		INSERT INTO people (first_name, last_name, email) SELECT 'Bulk', hex(randomblob(100)), 'bulk' || i || '@example.com' FROM n`)
+	sync()
+
+	// Checkpoint on every sync, so the next write restarts the WAL
+	r.checkpointFrames = 1
+	exec(t, "INSERT INTO people (first_name, last_name, email) VALUES ('Carol', 'Danvers', 'carol@example.com')")
+	sync()
+	salt := r.pos.salt1
+	exec(t, "INSERT INTO people (first_name, last_name, email) VALUES ('Dave', 'Bowman', 'dave@example.com')")
+	sync()
+	assert.Equal(t, salt+1, r.pos.salt1, "WAL restarted")
+
+	generations, err := r.generations(ctx)
+	require.NoError(t, err)
+	require.Len(t, generations, 1, "restarts after checkpointing continue the generation")
+	assert.Len(t, generations[0].segments, 5)
+
+	for i, s := range states {
+		assert.Equal(t, s.people, rebuiltPersons(t, r, s.at), "state %d", i)
+	}
+	_, err = r.Rebuild(ctx, generations[0].started.Add(-time.Second), filepath.Join(t.TempDir(), "none.db"))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Restoring the live database keeps replicating
+	restored, err := r.Restore(ctx, states[1].at)
+	require.NoError(t, err)
+	assert.True(t, restored.Before(states[1].at))
+	assert.Equal(t, states[1].people, persons(t, database.DB))
+	sync()
+	assert.Equal(t, states[1].people, rebuiltPersons(t, r, states[len(states)-1].at))
+}
+
+func TestReplicatorNewGenerations(t *testing.T) {
+	r, clock := setupReplica(t)
+	ctx := context.Background()
+	first := r.generation
+
+	// Checkpointing behind the replicator's back loses frames it didn't ship
+	exec(t, "INSERT INTO people (first_name, last_name, email) VALUES ('Alice', 'Liddell', 'alice@example.com')")
+	exec(t, "PRAGMA wal_checkpoint(TRUNCATE)")
+	exec(t, "INSERT INTO people (first_name, last_name, email) VALUES ('Carol', 'Danvers', 'carol@example.com')")
+	require.NoError(t, r.Sync(ctx))
+	second := r.generation
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, persons(t, database.DB), rebuiltPersons(t, r, r.now()))
+
+	// A checkpoint by the replicator ships the WAL first, so it restarts
+	// within the generation
+	exec(t, "INSERT INTO people (first_name, last_name, email) VALUES ('Dave', 'Bowman', 'dave@example.com')")
+	require.NoError(t, r.Checkpoint(ctx))
+	assert.True(t, r.pos.checkpointed)
+	exec(t, "INSERT INTO people (first_name, last_name, email) VALUES ('Eve', 'Moneypenny', 'eve@example.com')")
+	require.NoError(t, r.Sync(ctx))
+	assert.Equal(t, second, r.generation)
+	assert.Equal(t, persons(t, database.DB), rebuiltPersons(t, r, r.now()))
+
+	// A generation as old as the retention period is replaced, and the
+	// generations only covering times before it pruned
+	r.Retention = time.Hour
+	*clock = clock.Add(2 * time.Hour)
+	require.NoError(t, r.Sync(ctx))
+	generations, err := r.generations(ctx)
+	require.NoError(t, err)
+	require.Len(t, generations, 2)
+	assert.Equal(t, second, generations[0].name)
+	assert.Equal(t, r.generation, generations[1].name)
+	assert.Equal(t, persons(t, database.DB), rebuiltPersons(t, r, r.now()))
+}
@@ -0,0 +1,150 @@
+// Package certs configures TLS for the server: certificate reloading on file
+// change and optional client certificate authentication
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay coalesces the burst of events written by a certificate renewal
+const reloadDelay = 100 * time.Millisecond
+
+// ServerConfig builds the server TLS configuration, serving the certificate
+// from the returned reloader
+func ServerConfig(cfg config.TLS) (*tls.Config, *Reloader, error) {
+	reloader, err := NewReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+
+	if cfg.ClientAuth != config.ClientAuthNone {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("%s: no PEM certificates found", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.ClientAuth == config.ClientAuthRequire {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return tlsConfig, reloader, nil
+}
+
+// ClientUsername returns the common name of the verified client certificate
+// of a connection, which is the username it authenticates as
+func ClientUsername(state *tls.ConnectionState) (string, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	name := state.VerifiedChains[0][0].Subject.CommonName
+	return name, name != ""
+}
+
+// Reloader serves a certificate and key pair, reloading it when the files change
+type Reloader struct {
+	certFile, keyFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewReloader loads the certificate and key pair
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the certificate and key pair again, keeping the current one
+// if that fails
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch reloads the pair whenever its files change until ctx is done. It
+// watches the parent directories rather than the files, so replacing them by
+// rename, as cert-manager and Kubernetes secret mounts do, is seen too.
+func (r *Reloader) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	dirs := map[string]bool{filepath.Dir(r.certFile): true, filepath.Dir(r.keyFile): true}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			return err
+		}
+	}
+
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return errors.New("certificate watcher closed")
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			timer.Reset(reloadDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return errors.New("certificate watcher closed")
+			}
+			slog.Warn("Certificate watcher error", "error", err)
+		case <-timer.C:
+			if err := r.Reload(); err != nil {
+				slog.Error("Reloading TLS certificate failed, keeping the current one", "cert_file", r.certFile, "error", err)
+				continue
+			}
+			slog.Info("Reloaded TLS certificate", "cert_file", r.certFile)
+		}
+	}
+}
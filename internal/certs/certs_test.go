@@ -0,0 +1,169 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// issue creates a certificate for name signed by parent, self-signed when
+// parent is nil
+func issue(t *testing.T, name string, parent *tls.Certificate, isCA bool) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	signer, signerKey := template, any(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writePair writes cert and its key as PEM files in dir
+func writePair(t *testing.T, dir string, cert tls.Certificate) (string, string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	// Replace the files by rename, as secret mounts and renewal tools do
+	for path, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: cert.Certificate[0]},
+		keyFile:  {Type: "PRIVATE KEY", Bytes: keyDER},
+	} {
+		require.NoError(t, os.WriteFile(path+".tmp", pem.EncodeToMemory(block), 0o600))
+		require.NoError(t, os.Rename(path+".tmp", path))
+	}
+	return certFile, keyFile
+}
+
+func TestReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writePair(t, dir, issue(t, "first.test", nil, false))
+
+	reloader, err := NewReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- reloader.Watch(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	commonName := func() string {
+		cert, err := reloader.GetCertificate(nil)
+		require.NoError(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+		return leaf.Subject.CommonName
+	}
+	assert.Equal(t, "first.test", commonName())
+
+	// Give the watcher time to start before replacing the files
+	time.Sleep(50 * time.Millisecond)
+	writePair(t, dir, issue(t, "second.test", nil, false))
+	assert.Eventually(t, func() bool { return commonName() == "second.test" }, 5*time.Second, 20*time.Millisecond)
+
+	// A broken pair keeps the current certificate
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	time.Sleep(3 * reloadDelay)
+	assert.Equal(t, "second.test", commonName())
+}
+
+func TestServerConfigClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "Test CA", nil, true)
+	certFile, keyFile := writePair(t, dir, issue(t, "localhost", &ca, false))
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0o600))
+
+	tlsConfig, _, err := ServerConfig(config.TLS{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+		ClientAuth:   config.ClientAuthOptional,
+	})
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, _ := ClientUsername(r.TLS)
+		_, _ = w.Write([]byte(username))
+	}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	get := func(clientCerts ...tls.Certificate) string {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			Certificates: clientCerts,
+		}}}
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		return string(body[:n])
+	}
+
+	assert.Equal(t, "alice", get(issue(t, "alice", &ca, false)))
+	assert.Empty(t, get())
+
+	// Certificates from other CAs are rejected during the handshake, which
+	// TLS 1.2 reports to the client before any request is sent
+	mallory := issue(t, "mallory", nil, false)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		MaxVersion: tls.VersionTLS12,
+		RootCAs:    roots,
+		ServerName: "localhost",
+		// Send it although the server asks for certificates of its CA
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &mallory, nil
+		},
+	}}}
+	_, err = client.Get(server.URL)
+	assert.Error(t, err)
+}
@@ -0,0 +1,133 @@
+// Package compression negotiates gzip, zstd and brotli response compression
+// and decodes compressed request bodies
+package compression
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported content codings
+const (
+	Gzip   = "gzip"
+	Zstd   = "zstd"
+	Brotli = "br"
+)
+
+// Encodings lists the supported content codings
+var Encodings = []string{Zstd, Brotli, Gzip}
+
+// ErrUnsupportedEncoding is returned for content codings we can't decode
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// Negotiate picks the coding to compress a response with from an
+// Accept-Encoding header: the one with the highest quality, ties broken by
+// the order of offered. It returns "" when the response should be sent as is.
+func Negotiate(acceptEncoding string, offered []string) string {
+	best, bestQ := "", 0.0
+	wildcard := -1.0
+	accepted := make(map[string]float64)
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		accepted[coding] = q
+	}
+
+	for _, coding := range offered {
+		q, ok := accepted[coding]
+		if !ok {
+			q = max(wildcard, 0)
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// NewReader decodes a request body compressed with the given coding
+func NewReader(coding string, r io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(coding) {
+	case Gzip, "x-gzip":
+		return gzip.NewReader(r)
+	case Zstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case Brotli:
+		return io.NopCloser(brotli.NewReader(r)), nil
+	default:
+		return nil, ErrUnsupportedEncoding
+	}
+}
+
+// encoder is a compressing writer that can be reused for another output
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Encoders are expensive to allocate, zstd ones especially, so they are pooled
+var encoderPools = map[string]*sync.Pool{
+	Gzip: {New: func() any {
+		return gzip.NewWriter(io.Discard)
+	}},
+	Zstd: {New: func() any {
+		// Fails only on invalid options
+		e, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedDefault))
+		return e
+	}},
+	Brotli: {New: func() any {
+		return brotli.NewWriterLevel(io.Discard, 4)
+	}},
+}
+
+// getEncoder takes an encoder writing to w from the pool of coding
+func getEncoder(coding string, w io.Writer) encoder {
+	e := encoderPools[coding].Get().(encoder)
+	e.Reset(w)
+	return e
+}
+
+func putEncoder(coding string, e encoder) {
+	encoderPools[coding].Put(e)
+}
+
+// incompressible lists media type prefixes that are compressed already
+var incompressible = []string{"image/", "video/", "audio/", "font/woff", "application/zip", "application/gzip", "application/zstd"}
+
+func compressible(contentType string) bool {
+	if strings.HasPrefix(contentType, "image/svg") {
+		return true
+	}
+	return !slices.ContainsFunc(incompressible, func(prefix string) bool {
+		return strings.HasPrefix(contentType, prefix)
+	})
+}
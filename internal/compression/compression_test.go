@@ -0,0 +1,120 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	for _, tt := range []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", Gzip},
+		{"gzip, deflate, br", Brotli},
+		{"gzip, deflate, br, zstd", Zstd},
+		{"zstd;q=0.5, gzip", Gzip},
+		{"GZIP;q=0.8, br;q=0.9", Brotli},
+		{"*", Zstd},
+		{"*;q=0.5, zstd;q=0", Brotli},
+		{"gzip;q=0", ""},
+		{"gzip;q=abc", ""},
+	} {
+		assert.Equal(t, tt.want, Negotiate(tt.accept, Encodings), tt.accept)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	large := strings.Repeat("compress me ", 200)
+
+	r := gin.New()
+	r.Use(Middleware(Encodings, 1024))
+	r.GET("/large", func(c *gin.Context) { c.String(http.StatusOK, large) })
+	r.GET("/small", func(c *gin.Context) { c.String(http.StatusOK, "small") })
+	r.GET("/image", func(c *gin.Context) { c.Data(http.StatusOK, "image/png", []byte(large)) })
+	r.GET("/file", func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		http.ServeContent(c.Writer, c.Request, "file.txt", time.Time{}, strings.NewReader(large))
+	})
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", accept)
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		return w
+	}
+
+	for _, coding := range Encodings {
+		w := get("/large", coding)
+		assert.Equal(t, coding, w.Header().Get("Content-Encoding"))
+		assert.Less(t, w.Body.Len(), len(large))
+
+		body, err := NewReader(coding, w.Body)
+		require.NoError(t, err)
+		decoded, err := io.ReadAll(body)
+		require.NoError(t, err)
+		assert.Equal(t, large, string(decoded))
+
+		// Encoders come back from the pool in a clean state
+		w = get("/large", coding)
+		body, err = NewReader(coding, w.Body)
+		require.NoError(t, err)
+		decoded, err = io.ReadAll(body)
+		require.NoError(t, err)
+		assert.Equal(t, large, string(decoded))
+	}
+
+	w := get("/small", "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "small", w.Body.String())
+
+	w = get("/image", "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, large, w.Body.String())
+
+	w = get("/large", "")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, large, w.Body.String())
+
+	// Ranges are of the identity body, so they go out uncompressed
+	w = get("/file", "gzip")
+	assert.Equal(t, Gzip, w.Header().Get("Content-Encoding"))
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/file", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-1499")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "bytes 0-1499/2400", w.Header().Get("Content-Range"))
+	assert.Equal(t, large[:1500], w.Body.String())
+
+	// Unmatched routes still get gin's default body
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/missing", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "404 page not found", w.Body.String())
+}
+
+func TestNewReaderUnsupported(t *testing.T) {
+	_, err := NewReader("deflate", bytes.NewReader(nil))
+	assert.ErrorIs(t, err, ErrUnsupportedEncoding)
+}
@@ -0,0 +1,121 @@
+package compression
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware compresses responses with the best coding among offered the
+// client accepts. Responses shorter than minSize bytes, already encoded, of
+// compressed media types or partial are sent as is.
+func Middleware(offered []string, minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(offered) == 0 || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+
+		coding := Negotiate(c.GetHeader("Accept-Encoding"), offered)
+		if coding == "" {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		w := &responseWriter{ResponseWriter: original, coding: coding, minSize: minSize}
+		c.Writer = w
+		c.Next()
+		w.finish()
+		// Anything written after the middleware, like gin's default 404
+		// body, goes out uncompressed
+		c.Writer = original
+	}
+}
+
+// responseWriter buffers the start of the body until it knows whether the
+// response is worth compressing
+type responseWriter struct {
+	gin.ResponseWriter
+	coding  string
+	minSize int
+
+	buf     []byte
+	decided bool
+	enc     encoder
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		if err := w.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *responseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what has been written so far, for streamed responses
+func (w *responseWriter) Flush() {
+	if !w.decided {
+		_ = w.start()
+	}
+	if w.enc != nil {
+		_ = w.enc.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// start decides whether to compress and writes out the buffer
+func (w *responseWriter) start() error {
+	w.decided = true
+
+	h := w.Header()
+	if h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) && !partial(w.Status(), h) && len(w.buf) > 0 {
+		h.Set("Content-Encoding", w.coding)
+		h.Del("Content-Length")
+		w.enc = getEncoder(w.coding, w.ResponseWriter)
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// partial reports whether a response holds a byte range of the identity
+// body, which a compressed one wouldn't match
+func partial(status int, h http.Header) bool {
+	return status == http.StatusPartialContent || h.Get("Content-Range") != ""
+}
+
+// finish writes out a response below the threshold, or ends the compressed stream
+func (w *responseWriter) finish() {
+	if !w.decided {
+		w.decided = true
+		if len(w.buf) > 0 {
+			_, _ = w.ResponseWriter.Write(w.buf)
+		}
+		return
+	}
+	if w.enc != nil {
+		_ = w.enc.Close()
+		putEncoder(w.coding, w.enc)
+		w.enc = nil
+	}
+}
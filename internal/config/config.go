@@ -0,0 +1,717 @@
+// Package config loads the server configuration from defaults, a YAML or TOML
+// file, environment variables and command line flags, in increasing order of
+// precedence
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/backup"
+	"github.com/atrakic/gin-sqlite/internal/compression"
+	"github.com/atrakic/gin-sqlite/internal/ratelimit"
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/robfig/cron/v3"
+)
+
+// Config holds every server setting. Each field is addressed by its section
+// and key ("server.port") in config files, by its env tag in the environment
+// and by its flag tag on the command line; fields tagged secret are redacted
+// when printed.
+type Config struct {
+	Server      Server      `key:"server"`
+	Database    Database    `key:"database"`
+	Auth        Auth        `key:"auth"`
+	Docs        Docs        `key:"docs"`
+	Health      Health      `key:"health"`
+	Tracing     Tracing     `key:"tracing"`
+	Logging     Logging     `key:"logging"`
+	RateLimit   RateLimit   `key:"rate_limit"`
+	CORS        CORS        `key:"cors"`
+	Security    Security    `key:"security"`
+	TLS         TLS         `key:"tls"`
+	Compression Compression `key:"compression"`
+	Backup      Backup      `key:"backup"`
+	Maintenance Maintenance `key:"maintenance"`
+}
+
+// Server holds the HTTP server settings
+type Server struct {
+	Port              int           `key:"port" env:"PORT" flag:"port" usage:"HTTP listen port"`
+	ReadTimeout       time.Duration `key:"read_timeout" env:"READ_TIMEOUT" flag:"read-timeout" usage:"maximum duration for reading a request"`
+	ReadHeaderTimeout time.Duration `key:"read_header_timeout" env:"READ_HEADER_TIMEOUT" flag:"read-header-timeout" usage:"maximum duration for reading request headers"`
+	WriteTimeout      time.Duration `key:"write_timeout" env:"WRITE_TIMEOUT" flag:"write-timeout" usage:"maximum duration for writing a response"`
+	IdleTimeout       time.Duration `key:"idle_timeout" env:"IDLE_TIMEOUT" flag:"idle-timeout" usage:"maximum keep-alive idle time"`
+	ShutdownTimeout   time.Duration `key:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"time allowed to drain requests on shutdown"`
+	MaxHeaderBytes    int           `key:"max_header_bytes" env:"MAX_HEADER_BYTES" flag:"max-header-bytes" usage:"maximum size of request headers"`
+	MaxBodyBytes      int           `key:"max_body_bytes" env:"MAX_BODY_BYTES" flag:"max-body-bytes" usage:"maximum size of request bodies once decompressed, larger ones get 413"`
+	TrustedProxies    []string      `key:"trusted_proxies" env:"TRUSTED_PROXIES" flag:"trusted-proxies" usage:"comma separated IPs or CIDRs of the proxies whose X-Forwarded-For gives the client IP, empty trusts none"`
+}
+
+// Database holds the SQLite settings
+type Database struct {
+	File string `key:"file" env:"DATABASE_FILE" flag:"database-file" usage:"path of the SQLite database file"`
+}
+
+// Auth holds the authentication settings
+type Auth struct {
+	JWTSecret     string `key:"jwt_secret" env:"JWT_SECRET" flag:"jwt-secret" secret:"true" usage:"secret used to sign JWT tokens"`
+	AdminUser     string `key:"admin_user" env:"ADMIN_USER" flag:"admin-user" usage:"admin username"`
+	AdminPassword string `key:"admin_password" env:"ADMIN_PASSWORD" flag:"admin-password" secret:"true" usage:"admin password"`
+}
+
+// Docs holds the API documentation settings
+type Docs struct {
+	SwaggerFile string `key:"swagger_file" env:"SWAGGER_FILE" flag:"swagger-file" usage:"path of a swagger.json to serve instead of the embedded one"`
+}
+
+// Health holds the readiness check settings
+type Health struct {
+	Timeout       time.Duration `key:"timeout" env:"HEALTH_TIMEOUT" flag:"health-timeout" usage:"timeout of each readiness check"`
+	MinDiskFreeMB int           `key:"min_disk_free_mb" env:"HEALTH_MIN_DISK_FREE_MB" flag:"health-min-disk-free-mb" usage:"minimum free disk space next to the database file, in MiB"`
+	MaxWALSizeMB  int           `key:"max_wal_size_mb" env:"HEALTH_MAX_WAL_SIZE_MB" flag:"health-max-wal-size-mb" usage:"maximum size of the write-ahead log, in MiB (0 disables the check)"`
+}
+
+// Tracing holds the OpenTelemetry settings; the OTLP exporter reads its
+// endpoint, headers and protocol options from the standard OTEL_EXPORTER_OTLP_*
+// variables
+type Tracing struct {
+	Exporter    string `key:"exporter" env:"OTEL_TRACES_EXPORTER" flag:"tracing-exporter" usage:"trace exporter: none, otlp or stdout"`
+	File        string `key:"file" env:"TRACING_FILE" flag:"tracing-file" usage:"file the stdout exporter appends to, standard output when empty"`
+	ServiceName string `key:"service_name" env:"OTEL_SERVICE_NAME" flag:"service-name" usage:"service name reported on spans"`
+}
+
+// Logging holds the log settings
+type Logging struct {
+	Level string `key:"level" env:"LOG_LEVEL" flag:"log-level" usage:"minimum log level: debug, info, warn or error"`
+}
+
+// SlogLevel parses the configured level
+func (l Logging) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(l.Level))
+	return level, err
+}
+
+// RateLimit holds the per-client rate limiting settings. Limits are written
+// as "<requests>/<period>", such as "100/1m", and apply per route and client.
+type RateLimit struct {
+	Default      string   `key:"default" env:"RATE_LIMIT_DEFAULT" flag:"rate-limit-default" usage:"limit of routes without their own, empty for unlimited"`
+	Routes       []string `key:"routes" env:"RATE_LIMIT_ROUTES" flag:"rate-limit-routes" usage:"comma separated per-route limits such as \"POST /auth/login=5/1m\""`
+	Store        string   `key:"store" env:"RATE_LIMIT_STORE" flag:"rate-limit-store" usage:"bucket store: memory, or sqlite to persist limits across restarts, written every 5s in one transaction and not in maintenance mode"`
+	APIKeyHeader string   `key:"api_key_header" env:"RATE_LIMIT_API_KEY_HEADER" flag:"rate-limit-api-key-header" usage:"header identifying clients by API key, only set it when a proxy verifies the keys"`
+}
+
+// Rate limit bucket stores selectable with rate_limit.store
+const (
+	StoreMemory = "memory"
+	// StoreSQLite keeps the buckets in memory too and saves those changed
+	// every few seconds, so that requests don't wait for the single writer
+	// connection. Nothing is saved in maintenance mode.
+	StoreSQLite = "sqlite"
+)
+
+// Limits parses the default and per-route limits
+func (r RateLimit) Limits() (ratelimit.Limit, map[string]ratelimit.Limit, error) {
+	def, err := ratelimit.ParseLimit(r.Default)
+	if err != nil {
+		return ratelimit.Limit{}, nil, err
+	}
+	routes, err := ratelimit.ParseRoutes(r.Routes)
+	return def, routes, err
+}
+
+// CORS holds the cross-origin resource sharing policy for browser clients
+type CORS struct {
+	AllowedOrigins   []string      `key:"allowed_origins" env:"CORS_ALLOWED_ORIGINS" flag:"cors-allowed-origins" usage:"comma separated origins allowed to call the API, * for any, empty disables CORS"`
+	AllowedMethods   []string      `key:"allowed_methods" env:"CORS_ALLOWED_METHODS" flag:"cors-allowed-methods" usage:"comma separated methods allowed in cross-origin requests"`
+	AllowedHeaders   []string      `key:"allowed_headers" env:"CORS_ALLOWED_HEADERS" flag:"cors-allowed-headers" usage:"comma separated request headers allowed in cross-origin requests"`
+	AllowCredentials bool          `key:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS" flag:"cors-allow-credentials" usage:"allow cross-origin requests with credentials"`
+	MaxAge           time.Duration `key:"max_age" env:"CORS_MAX_AGE" flag:"cors-max-age" usage:"how long browsers may cache preflight responses"`
+}
+
+// Security holds the security response header settings
+type Security struct {
+	HSTSMaxAge            time.Duration `key:"hsts_max_age" env:"SECURITY_HSTS_MAX_AGE" flag:"hsts-max-age" usage:"Strict-Transport-Security max-age sent over TLS, 0 disables it"`
+	ContentSecurityPolicy string        `key:"content_security_policy" env:"SECURITY_CSP" flag:"content-security-policy" usage:"Content-Security-Policy of API responses"`
+}
+
+// TLS holds the HTTPS settings; the certificate and key are reloaded when
+// their files change
+type TLS struct {
+	CertFile     string `key:"cert_file" env:"TLS_CERT_FILE" flag:"tls-cert-file" usage:"PEM certificate chain, enables HTTPS"`
+	KeyFile      string `key:"key_file" env:"TLS_KEY_FILE" flag:"tls-key-file" usage:"PEM private key of the certificate"`
+	ClientCAFile string `key:"client_ca_file" env:"TLS_CLIENT_CA_FILE" flag:"tls-client-ca-file" usage:"PEM CA certificates verifying client certificates"`
+	ClientAuth   string `key:"client_auth" env:"TLS_CLIENT_AUTH" flag:"tls-client-auth" usage:"client certificates: none, optional or require; the certificate common name is the username"`
+}
+
+// Client certificate policies selectable with tls.client_auth
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// Enabled reports whether the server serves HTTPS
+func (t TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// Compression holds the response compression settings
+type Compression struct {
+	Encodings []string `key:"encodings" env:"COMPRESSION_ENCODINGS" flag:"compression-encodings" usage:"comma separated content codings in order of preference: zstd, br, gzip; empty disables compression"`
+	MinSize   int      `key:"min_size" env:"COMPRESSION_MIN_SIZE" flag:"compression-min-size" usage:"minimum response size in bytes worth compressing"`
+}
+
+// Backup holds the database backup settings. Backups are named after the
+// time they were taken; the newest backup of each of the last keep_hourly
+// hours and keep_daily days is kept, older ones are pruned. Between
+// backups, the write-ahead log can be shipped to the same target every
+// wal_interval for point-in-time recovery.
+type Backup struct {
+	Target         string        `key:"target" env:"BACKUP_TARGET" flag:"backup-target" usage:"where backups are stored: dir, s3 or azure"`
+	Dir            string        `key:"dir" env:"BACKUP_DIR" flag:"backup-dir" usage:"directory of the dir target, empty disables it"`
+	Prefix         string        `key:"prefix" env:"BACKUP_PREFIX" flag:"backup-prefix" usage:"prefix of object names in the s3 and azure targets"`
+	Interval       time.Duration `key:"interval" env:"BACKUP_INTERVAL" flag:"backup-interval" usage:"how often the server takes a backup, 0 for on demand only"`
+	Compression    string        `key:"compression" env:"BACKUP_COMPRESSION" flag:"backup-compression" usage:"backup compression: gzip, zstd or none"`
+	KeepHourly     int           `key:"keep_hourly" env:"BACKUP_KEEP_HOURLY" flag:"backup-keep-hourly" usage:"number of hours to keep an hourly backup of"`
+	KeepDaily      int           `key:"keep_daily" env:"BACKUP_KEEP_DAILY" flag:"backup-keep-daily" usage:"number of days to keep a daily backup of"`
+	WALInterval    time.Duration `key:"wal_interval" env:"BACKUP_WAL_INTERVAL" flag:"backup-wal-interval" usage:"how often new write-ahead log frames are shipped to the target, 0 disables point-in-time recovery"`
+	WALRetention   time.Duration `key:"wal_retention" env:"BACKUP_WAL_RETENTION" flag:"backup-wal-retention" usage:"how far back point-in-time recovery reaches; a new snapshot is taken as often"`
+	S3Endpoint     string        `key:"s3_endpoint" env:"BACKUP_S3_ENDPOINT" flag:"backup-s3-endpoint" usage:"host[:port] of the S3 compatible API"`
+	S3Region       string        `key:"s3_region" env:"BACKUP_S3_REGION" flag:"backup-s3-region" usage:"region of the S3 bucket, detected when empty"`
+	S3Bucket       string        `key:"s3_bucket" env:"BACKUP_S3_BUCKET" flag:"backup-s3-bucket" usage:"bucket of the s3 target, empty disables it"`
+	S3AccessKey    string        `key:"s3_access_key" env:"AWS_ACCESS_KEY_ID" flag:"backup-s3-access-key" usage:"S3 access key, the AWS credential chain is used when empty"`
+	S3SecretKey    string        `key:"s3_secret_key" env:"AWS_SECRET_ACCESS_KEY" flag:"backup-s3-secret-key" secret:"true" usage:"S3 secret key"`
+	S3Insecure     bool          `key:"s3_insecure" env:"BACKUP_S3_INSECURE" flag:"backup-s3-insecure" usage:"talk plain HTTP to the S3 endpoint"`
+	AzureAccount   string        `key:"azure_account" env:"AZURE_STORAGE_ACCOUNT" flag:"backup-azure-account" usage:"Azure Storage account of the azure target"`
+	AzureKey       string        `key:"azure_key" env:"AZURE_STORAGE_KEY" flag:"backup-azure-key" secret:"true" usage:"Azure Storage account key"`
+	AzureContainer string        `key:"azure_container" env:"BACKUP_AZURE_CONTAINER" flag:"backup-azure-container" usage:"blob container of the azure target, empty disables it"`
+	AzureEndpoint  string        `key:"azure_endpoint" env:"BACKUP_AZURE_ENDPOINT" flag:"backup-azure-endpoint" usage:"blob service URL, such as Azurite's, empty for the account's public endpoint"`
+}
+
+// Maintenance holds the schedules of the database maintenance jobs, as cron
+// expressions with five fields or descriptors such as @daily, optionally
+// prefixed with CRON_TZ=<zone>; an empty schedule disables the job. It also
+// holds the settings of the read-only maintenance mode.
+type Maintenance struct {
+	Checkpoint       string        `key:"checkpoint" env:"MAINTENANCE_CHECKPOINT" flag:"maintenance-checkpoint" usage:"schedule of PRAGMA wal_checkpoint(TRUNCATE), shipping the WAL first when it is replicated"`
+	Vacuum           string        `key:"vacuum" env:"MAINTENANCE_VACUUM" flag:"maintenance-vacuum" usage:"schedule of the incremental vacuum returning free pages to the file system"`
+	Optimize         string        `key:"optimize" env:"MAINTENANCE_OPTIMIZE" flag:"maintenance-optimize" usage:"schedule of PRAGMA optimize, which runs ANALYZE where query planning benefits"`
+	IntegrityCheck   string        `key:"integrity_check" env:"MAINTENANCE_INTEGRITY_CHECK" flag:"maintenance-integrity-check" usage:"schedule of PRAGMA integrity_check"`
+	PurgeDeleted     string        `key:"purge_deleted" env:"MAINTENANCE_PURGE_DELETED" flag:"maintenance-purge-deleted" usage:"schedule of the permanent removal of persons deleted longer than deleted_retention ago"`
+	DeletedRetention time.Duration `key:"deleted_retention" env:"MAINTENANCE_DELETED_RETENTION" flag:"maintenance-deleted-retention" usage:"how long deleted persons can be restored before they are purged"`
+	RetryAfter       time.Duration `key:"retry_after" env:"MAINTENANCE_RETRY_AFTER" flag:"maintenance-retry-after" usage:"Retry-After sent with writes rejected in maintenance mode, unless given when entering it"`
+}
+
+// Backup targets selectable with backup.target
+const (
+	TargetDir   = "dir"
+	TargetS3    = "s3"
+	TargetAzure = "azure"
+)
+
+// Enabled reports whether the selected backup target is configured
+func (b Backup) Enabled() bool {
+	switch b.Target {
+	case TargetDir:
+		return b.Dir != ""
+	case TargetS3:
+		return b.S3Bucket != ""
+	case TargetAzure:
+		return b.AzureContainer != ""
+	}
+	return false
+}
+
+// WALEnabled reports whether the write-ahead log is shipped to the backup
+// target
+func (b Backup) WALEnabled() bool {
+	return b.Enabled() && b.WALInterval > 0
+}
+
+// Trace exporters selectable with tracing.exporter
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// ConfigFileEnv names the environment variable pointing at a config file,
+// the -config flag takes precedence over it
+const ConfigFileEnv = "CONFIG_FILE"
+
+// redacted replaces secret values when printing the configuration
+const redacted = "REDACTED"
+
+// Default returns the configuration used when nothing else is set
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:              8080,
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			ShutdownTimeout:   20 * time.Second,
+			MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+			MaxBodyBytes:      1 << 20,
+		},
+		Auth: Auth{
+			JWTSecret:     "your-secret-key",
+			AdminUser:     "admin",
+			AdminPassword: "secret",
+		},
+		Health: Health{
+			Timeout:       2 * time.Second,
+			MinDiskFreeMB: 100,
+			MaxWALSizeMB:  64,
+		},
+		Tracing: Tracing{
+			Exporter:    ExporterNone,
+			ServiceName: "gin-sqlite",
+		},
+		Logging: Logging{
+			Level: "info",
+		},
+		RateLimit: RateLimit{
+			Default: "600/1m",
+			Routes:  []string{"POST /auth/login=10/1m", "POST /api/v2/auth/login=10/1m"},
+			Store:   StoreMemory,
+		},
+		CORS: CORS{
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "Accept", "X-Request-ID"},
+			MaxAge:         12 * time.Hour,
+		},
+		Security: Security{
+			HSTSMaxAge:            365 * 24 * time.Hour,
+			ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'",
+		},
+		TLS: TLS{
+			ClientAuth: ClientAuthNone,
+		},
+		Compression: Compression{
+			Encodings: []string{"zstd", "br", "gzip"},
+			MinSize:   1024,
+		},
+		Backup: Backup{
+			Target:       TargetDir,
+			S3Endpoint:   "s3.amazonaws.com",
+			Interval:     time.Hour,
+			Compression:  backup.CompressionGzip,
+			KeepHourly:   24,
+			KeepDaily:    31,
+			WALRetention: 24 * time.Hour,
+		},
+		Maintenance: Maintenance{
+			Checkpoint:       "*/15 * * * *",
+			Vacuum:           "0 3 * * *",
+			Optimize:         "0 * * * *",
+			IntegrityCheck:   "0 4 * * 0",
+			PurgeDeleted:     "30 3 * * *",
+			DeletedRetention: 30 * 24 * time.Hour,
+			RetryAfter:       time.Minute,
+		},
+	}
+}
+
+// Load builds the configuration from defaults, the config file, the
+// environment and the given command line arguments, then validates it
+func Load(name string, args []string) (*Config, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := fs.String("config", os.Getenv(ConfigFileEnv), "path of a YAML or TOML config file")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.flag] = fs.String(s.flag, "", s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if *configFile != "" {
+		values, err := readFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range settings {
+			if v, ok := values[s.key]; ok {
+				if err := s.set(v); err != nil {
+					return nil, fmt.Errorf("%s: %s: %w", *configFile, s.key, err)
+				}
+				delete(values, s.key)
+			}
+		}
+		for key := range values {
+			return nil, fmt.Errorf("%s: unknown setting %q", *configFile, key)
+		}
+	}
+
+	// Empty variables count as unset, as compose files often pass them through
+	for _, s := range settings {
+		if v := os.Getenv(s.env); v != "" {
+			if err := s.set(v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				if err := s.set(*flagValues[f.Name]); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Database.File == "" {
+		errs = append(errs, errors.New("database.file (DATABASE_FILE) must be set"))
+	}
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port (PORT) must be between 1 and 65535, got %d", c.Server.Port))
+	}
+	if c.Server.MaxHeaderBytes <= 0 {
+		errs = append(errs, errors.New("server.max_header_bytes (MAX_HEADER_BYTES) must be positive"))
+	}
+	if c.Server.MaxBodyBytes <= 0 {
+		errs = append(errs, errors.New("server.max_body_bytes (MAX_BODY_BYTES) must be positive"))
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			errs = append(errs, fmt.Errorf("server.trusted_proxies (TRUSTED_PROXIES) must be IPs or CIDRs, got %q", proxy))
+		}
+	}
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		{"health.timeout", c.Health.Timeout},
+		{"cors.max_age", c.CORS.MaxAge},
+		{"security.hsts_max_age", c.Security.HSTSMaxAge},
+		{"backup.interval", c.Backup.Interval},
+		{"backup.wal_interval", c.Backup.WALInterval},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", d.key))
+		}
+	}
+	if c.Health.MinDiskFreeMB < 0 || c.Health.MaxWALSizeMB < 0 {
+		errs = append(errs, errors.New("health.min_disk_free_mb and health.max_wal_size_mb must not be negative"))
+	}
+	switch c.Tracing.Exporter {
+	case ExporterNone, ExporterOTLP, ExporterStdout:
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter (OTEL_TRACES_EXPORTER) must be none, otlp or stdout, got %q", c.Tracing.Exporter))
+	}
+	if c.Tracing.ServiceName == "" {
+		errs = append(errs, errors.New("tracing.service_name (OTEL_SERVICE_NAME) must be set"))
+	}
+	if _, err := c.Logging.SlogLevel(); err != nil {
+		errs = append(errs, fmt.Errorf("logging.level (LOG_LEVEL) must be debug, info, warn or error, got %q", c.Logging.Level))
+	}
+	if _, _, err := c.RateLimit.Limits(); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limit: %w", err))
+	}
+	if c.RateLimit.Store != StoreMemory && c.RateLimit.Store != StoreSQLite {
+		errs = append(errs, fmt.Errorf("rate_limit.store (RATE_LIMIT_STORE) must be memory or sqlite, got %q", c.RateLimit.Store))
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			if c.CORS.AllowCredentials {
+				errs = append(errs, errors.New("cors.allowed_origins (CORS_ALLOWED_ORIGINS) can't be * when cors.allow_credentials is set"))
+			}
+		} else if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			errs = append(errs, fmt.Errorf("cors.allowed_origins (CORS_ALLOWED_ORIGINS) must be * or http(s) origins, got %q", origin))
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls.cert_file and tls.key_file (TLS_CERT_FILE, TLS_KEY_FILE) must be set together"))
+	}
+	switch c.TLS.ClientAuth {
+	case ClientAuthNone:
+	case ClientAuthOptional, ClientAuthRequire:
+		if !c.TLS.Enabled() || c.TLS.ClientCAFile == "" {
+			errs = append(errs, errors.New("tls.client_auth (TLS_CLIENT_AUTH) needs tls.cert_file and tls.client_ca_file"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tls.client_auth (TLS_CLIENT_AUTH) must be none, optional or require, got %q", c.TLS.ClientAuth))
+	}
+	for _, coding := range c.Compression.Encodings {
+		if !slices.Contains(compression.Encodings, coding) {
+			errs = append(errs, fmt.Errorf("compression.encodings (COMPRESSION_ENCODINGS) must be zstd, br or gzip, got %q", coding))
+		}
+	}
+	if c.Compression.MinSize < 0 {
+		errs = append(errs, errors.New("compression.min_size (COMPRESSION_MIN_SIZE) must not be negative"))
+	}
+	if !slices.Contains(backup.Compressions, c.Backup.Compression) {
+		errs = append(errs, fmt.Errorf("backup.compression (BACKUP_COMPRESSION) must be gzip, zstd or none, got %q", c.Backup.Compression))
+	}
+	if c.Backup.KeepHourly < 0 || c.Backup.KeepDaily < 0 {
+		errs = append(errs, errors.New("backup.keep_hourly and backup.keep_daily must not be negative"))
+	}
+	if c.Backup.WALInterval > 0 && c.Backup.WALRetention <= 0 {
+		errs = append(errs, errors.New("backup.wal_retention (BACKUP_WAL_RETENTION) must be positive"))
+	}
+	switch c.Backup.Target {
+	case TargetDir:
+	case TargetS3:
+		if c.Backup.S3Endpoint == "" || strings.Contains(c.Backup.S3Endpoint, "/") {
+			errs = append(errs, fmt.Errorf("backup.s3_endpoint (BACKUP_S3_ENDPOINT) must be a host and optional port, got %q", c.Backup.S3Endpoint))
+		}
+	case TargetAzure:
+		if c.Backup.AzureContainer != "" && (c.Backup.AzureAccount == "" || c.Backup.AzureKey == "") {
+			errs = append(errs, errors.New("backup.azure_account and backup.azure_key (AZURE_STORAGE_ACCOUNT, AZURE_STORAGE_KEY) must be set"))
+		}
+		if _, err := base64.StdEncoding.DecodeString(c.Backup.AzureKey); err != nil {
+			errs = append(errs, errors.New("backup.azure_key (AZURE_STORAGE_KEY) must be base64 encoded"))
+		}
+		if e := c.Backup.AzureEndpoint; e != "" && !strings.HasPrefix(e, "http://") && !strings.HasPrefix(e, "https://") {
+			errs = append(errs, fmt.Errorf("backup.azure_endpoint (BACKUP_AZURE_ENDPOINT) must be an http(s) URL, got %q", e))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("backup.target (BACKUP_TARGET) must be dir, s3 or azure, got %q", c.Backup.Target))
+	}
+	if c.Maintenance.DeletedRetention <= 0 {
+		errs = append(errs, errors.New("maintenance.deleted_retention (MAINTENANCE_DELETED_RETENTION) must be positive"))
+	}
+	if c.Maintenance.RetryAfter < time.Second {
+		errs = append(errs, errors.New("maintenance.retry_after (MAINTENANCE_RETRY_AFTER) must be at least 1s"))
+	}
+	for _, m := range []struct{ key, env, schedule string }{
+		{"maintenance.checkpoint", "MAINTENANCE_CHECKPOINT", c.Maintenance.Checkpoint},
+		{"maintenance.vacuum", "MAINTENANCE_VACUUM", c.Maintenance.Vacuum},
+		{"maintenance.optimize", "MAINTENANCE_OPTIMIZE", c.Maintenance.Optimize},
+		{"maintenance.integrity_check", "MAINTENANCE_INTEGRITY_CHECK", c.Maintenance.IntegrityCheck},
+		{"maintenance.purge_deleted", "MAINTENANCE_PURGE_DELETED", c.Maintenance.PurgeDeleted},
+	} {
+		if _, err := cron.ParseStandard(m.schedule); m.schedule != "" && err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s) must be a cron expression: %w", m.key, m.env, err))
+		}
+	}
+	if c.Auth.JWTSecret == "" {
+		errs = append(errs, errors.New("auth.jwt_secret (JWT_SECRET) must be set"))
+	}
+	if c.Auth.AdminUser == "" || c.Auth.AdminPassword == "" {
+		errs = append(errs, errors.New("auth.admin_user and auth.admin_password (ADMIN_USER, ADMIN_PASSWORD) must be set"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Addr returns the address the HTTP server listens on
+func (c *Config) Addr() string {
+	return ":" + strconv.Itoa(c.Server.Port)
+}
+
+// Print writes the effective configuration as YAML, with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	section := ""
+	for _, s := range c.settings() {
+		name, key, _ := strings.Cut(s.key, ".")
+		if name != section {
+			if _, err := fmt.Fprintf(w, "%s:\n", name); err != nil {
+				return err
+			}
+			section = name
+		}
+
+		value := s.format()
+		if s.secret && s.value.String() != "" {
+			value = strconv.Quote(redacted)
+		}
+		if _, err := fmt.Fprintf(w, "  %s: %s # %s\n", key, value, s.env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Usage describes the available flags and environment variables
+func Usage(w io.Writer, name string) {
+	fmt.Fprintf(w, "Usage: %s [flags]\n       %s config print [flags]\n       %s backup [flags]\n       %s restore <file|backup|time> [flags]\n\nFlags:\n", name, name, name, name)
+	fmt.Fprintf(w, "  -config string\n\tpath of a YAML or TOML config file (env %s)\n", ConfigFileEnv)
+	for _, s := range Default().settings() {
+		fmt.Fprintf(w, "  -%s %s\n\t%s (env %s, default %s)\n", s.flag, s.value.Type(), s.usage, s.env, s.format())
+	}
+}
+
+// setting is a single configuration field along with its names per source
+type setting struct {
+	key    string
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// settings walks the config sections and returns their fields in order
+func (c *Config) settings() []setting {
+	var settings []setting
+
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Type().Field(i).Tag.Get("key")
+		fields := root.Field(i)
+		for j := 0; j < fields.NumField(); j++ {
+			tag := fields.Type().Field(j).Tag
+			settings = append(settings, setting{
+				key:    section + "." + tag.Get("key"),
+				env:    tag.Get("env"),
+				flag:   tag.Get("flag"),
+				usage:  tag.Get("usage"),
+				secret: tag.Get("secret") == "true",
+				value:  fields.Field(j),
+			})
+		}
+	}
+
+	return settings
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses a string value into the setting's field
+func (s setting) set(raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	switch {
+	case s.value.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(d))
+	case s.value.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		s.value.SetInt(int64(n))
+	case s.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		s.value.SetBool(b)
+	case s.value.Kind() == reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		s.value.Set(reflect.ValueOf(items))
+	default:
+		s.value.SetString(raw)
+	}
+	return nil
+}
+
+// format renders the setting's value as a YAML scalar or flow sequence
+func (s setting) format() string {
+	switch {
+	case s.value.Type() == durationType:
+		return strconv.Quote(time.Duration(s.value.Int()).String())
+	case s.value.Kind() == reflect.Int:
+		return strconv.FormatInt(s.value.Int(), 10)
+	case s.value.Kind() == reflect.Bool:
+		return strconv.FormatBool(s.value.Bool())
+	case s.value.Kind() == reflect.Slice:
+		items := make([]string, s.value.Len())
+		for i := range items {
+			items[i] = strconv.Quote(s.value.Index(i).String())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return strconv.Quote(s.value.String())
+	}
+}
+
+// readFile reads a YAML or TOML config file into flat "section.key" values
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("%s: unsupported config file format, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	for section, fields := range raw {
+		table, ok := fields.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: %q must be a section", path, section)
+		}
+		for key, value := range table {
+			values[section+"."+key] = formatFileValue(value)
+		}
+	}
+	return values, nil
+}
+
+// formatFileValue turns a decoded file value into the string form set expects
+func formatFileValue(value any) string {
+	if list, ok := value.([]any); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+server:
+  port: 9000
+  read_timeout: 3s
+  write_timeout: 10s
+database:
+  file: /from/file.db
+auth:
+  admin_user: file-admin
+`)
+
+	t.Setenv(ConfigFileEnv, path)
+	t.Setenv("PORT", "9001")
+	t.Setenv("DATABASE_FILE", "/from/env.db")
+	t.Setenv("ADMIN_PASSWORD", "")
+
+	cfg, err := Load("test", []string{"-database-file", "/from/flag.db", "-write-timeout=1m"})
+	require.NoError(t, err)
+
+	assert.Equal(t, 9001, cfg.Server.Port, "env overrides file")
+	assert.Equal(t, 3*time.Second, cfg.Server.ReadTimeout, "file overrides default")
+	assert.Equal(t, time.Minute, cfg.Server.WriteTimeout, "flag overrides file")
+	assert.Equal(t, "/from/flag.db", cfg.Database.File, "flag overrides env")
+	assert.Equal(t, "file-admin", cfg.Auth.AdminUser)
+	assert.Equal(t, "secret", cfg.Auth.AdminPassword, "empty env keeps default")
+	assert.Equal(t, 20*time.Second, cfg.Server.ShutdownTimeout, "default")
+	assert.Equal(t, ":9001", cfg.Addr())
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+[server]
+port = 7000
+idle_timeout = "2m"
+
+[database]
+file = "toml.db"
+`)
+
+	cfg, err := Load("test", []string{"-config", path})
+	require.NoError(t, err)
+	assert.Equal(t, 7000, cfg.Server.Port)
+	assert.Equal(t, 2*time.Minute, cfg.Server.IdleTimeout)
+	assert.Equal(t, "toml.db", cfg.Database.File)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	t.Setenv("DATABASE_FILE", "")
+
+	_, err := Load("test", nil)
+	assert.ErrorContains(t, err, "DATABASE_FILE")
+
+	_, err = Load("test", []string{"-database-file", "x.db", "-port", "70000"})
+	assert.ErrorContains(t, err, "server.port")
+
+	_, err = Load("test", []string{"-database-file", "x.db", "-trusted-proxies", "10.0.0.0/8,proxy.local"})
+	assert.ErrorContains(t, err, "TRUSTED_PROXIES")
+
+	_, err = Load("test", []string{"-database-file", "x.db", "-tracing-exporter", "jaeger"})
+	assert.ErrorContains(t, err, "tracing.exporter")
+
+	_, err = Load("test", []string{"-database-file", "x.db", "-backup-target", "ftp"})
+	assert.ErrorContains(t, err, "backup.target")
+
+	_, err = Load("test", []string{"-database-file", "x.db", "-backup-target", "azure", "-backup-azure-container", "backups"})
+	assert.ErrorContains(t, err, "AZURE_STORAGE_KEY")
+
+	_, err = Load("test", []string{"-database-file", "x.db", "-backup-wal-interval", "10s", "-backup-wal-retention", "0s"})
+	assert.ErrorContains(t, err, "backup.wal_retention")
+	_, err = Load("test", []string{"-database-file", "x.db", "-maintenance-vacuum", "nightly"})
+	assert.ErrorContains(t, err, "MAINTENANCE_VACUUM")
+
+	_, err = Load("test", []string{"-database-file", "x.db", "-maintenance-retry-after", "0s"})
+	assert.ErrorContains(t, err, "maintenance.retry_after")
+
+	_, err = Load("test", []string{"-database-file", "x.db", "-maintenance-deleted-retention", "0s"})
+	assert.ErrorContains(t, err, "MAINTENANCE_DELETED_RETENTION")
+
+	t.Setenv("READ_TIMEOUT", "soon")
+	_, err = Load("test", []string{"-database-file", "x.db"})
+	assert.ErrorContains(t, err, "READ_TIMEOUT")
+
+	_, err = Load("test", []string{"-config", writeFile(t, "typo.yaml", "server:\n  prot: 1\n")})
+	assert.ErrorContains(t, err, `unknown setting "server.prot"`)
+
+	_, err = Load("test", []string{"-config", writeFile(t, "config.ini", "")})
+	assert.ErrorContains(t, err, "unsupported config file format")
+}
+
+func TestPrintRedactsSecretsAndRoundTrips(t *testing.T) {
+	cfg, err := Load("test", []string{"-database-file", "print.db", "-jwt-secret", "s3cr3t", "-port", "8181"})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, cfg.Print(&buf))
+
+	out := buf.String()
+	assert.NotContains(t, out, "s3cr3t")
+	assert.Contains(t, out, `jwt_secret: "REDACTED"`)
+	assert.Contains(t, out, `file: "print.db"`)
+
+	// The printed config is itself a valid config file
+	reloaded, err := Load("test", []string{"-config", writeFile(t, "printed.yaml", out)})
+	require.NoError(t, err)
+	assert.Equal(t, cfg.Server, reloaded.Server)
+	assert.Equal(t, cfg.Database, reloaded.Database)
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"modernc.org/sqlite"
+)
+
+// VacuumInto writes a compacted, consistent copy of the database to path
+// while it stays online; path must not exist yet
+func VacuumInto(ctx context.Context, path string) error {
+	const query = "VACUUM INTO ?"
+	ctx, done := startQuery(ctx, "vacuum_into", query)
+	defer done()
+
+	// A read, so it doesn't hold up writers
+	_, err := ReadDB.ExecContext(ctx, query, path)
+	return err
+}
+
+// restorer is implemented by modernc.org/sqlite connections
+type restorer interface {
+	NewRestore(srcURI string) (*sqlite.Backup, error)
+}
+
+// Restore replaces the contents of the database with the SQLite database
+// file at path using the online backup API, so open connections see the
+// restored data, then migrates it to the current schema version
+func Restore(ctx context.Context, path string) error {
+	ctx, done := startQuery(ctx, "restore", "")
+	defer done()
+
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = conn.Raw(func(driverConn any) error {
+		r, ok := driverConn.(restorer)
+		if !ok {
+			return fmt.Errorf("driver connection %T does not support restoring", driverConn)
+		}
+		b, err := r.NewRestore("file:" + path + "?mode=ro")
+		if err != nil {
+			return err
+		}
+		if _, err := b.Step(-1); err != nil {
+			_ = b.Finish()
+			return err
+		}
+		return b.Finish()
+	})
+	// migrate needs the writer connection back
+	err = errors.Join(err, conn.Close())
+	if err != nil {
+		return fmt.Errorf("restoring %s: %w", path, err)
+	}
+
+	return migrate()
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/models"
+)
+
+// DbGetPersonChanges returns up to limit entries of the person change feed
+// following sequence number since, in order
+func DbGetPersonChanges(ctx context.Context, since int64, limit int) ([]models.PersonChange, error) {
+	const query = `SELECT seq, operation, person_id, changed_at, changed_by, first_name, last_name, email, created_at, created_by
+		FROM person_changes WHERE seq > ? ORDER BY seq LIMIT ?`
+	ctx, done := startQuery(ctx, "get_person_changes", query)
+	defer done()
+
+	db, release := reader()
+	defer release()
+
+	rows, err := db.QueryContext(ctx, query, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make([]models.PersonChange, 0)
+	for rows.Next() {
+		var change models.PersonChange
+		var firstName, lastName, email, createdBy sql.NullString
+		var createdAt sql.NullInt64
+		if err := rows.Scan(&change.Seq, &change.Operation, &change.PersonID, unixTime{&change.ChangedAt}, &change.ChangedBy,
+			&firstName, &lastName, &email, &createdAt, &createdBy); err != nil {
+			return nil, err
+		}
+		// Tombstones carry no data
+		if email.Valid {
+			person := models.Person{
+				ID:        change.PersonID,
+				FirstName: firstName.String,
+				LastName:  lastName.String,
+				Email:     email.String,
+				UpdatedAt: change.ChangedAt,
+				UpdatedBy: change.ChangedBy,
+				CreatedAt: time.Unix(0, createdAt.Int64).UTC(),
+				CreatedBy: createdBy.String,
+			}
+			change.Person = &person
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
@@ -1,86 +1,221 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"log"
-	"os"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/atrakic/gin-sqlite/internal/models"
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
-// DB is ...
+// DB is the writer pool. It holds a single connection, so writers queue in
+// the pool instead of contending for the SQLite write lock, and its
+// transactions begin IMMEDIATE, taking the lock upfront rather than failing
+// with SQLITE_BUSY when a read transaction tries to upgrade to a write.
 var DB *sql.DB
 
-// ConnectDatabase is ...
-func ConnectDatabase() error {
-	dataSourceName := os.Getenv("DATABASE_FILE")
-	db, err := sql.Open("sqlite", dataSourceName)
+// ReadDB is the pool of read-only connections store reads are routed to,
+// which in WAL mode run alongside the writer; it is DB for in-memory
+// databases
+var ReadDB *sql.DB
+
+// ErrDuplicate is returned when a write violates a uniqueness constraint,
+// such as two persons sharing an email address
+var ErrDuplicate = errors.New("duplicate record")
+
+// dataSource is the data source name DB was opened with
+var dataSource string
+
+// busyTimeout is how long a connection waits for a lock held by another
+// process, or by the WAL replicator, before failing with SQLITE_BUSY
+const busyTimeout = "_pragma=busy_timeout(5000)"
+
+// ConnectDatabase opens the SQLite database at dataSourceName in WAL mode,
+// with a single connection writer pool and a read-only reader pool
+func ConnectDatabase(dataSourceName string) error {
+	writeSource := withParams(dataSourceName, "_txlock=immediate", busyTimeout, "_pragma=journal_mode(WAL)")
+	db, err := sql.Open("sqlite", writeSource)
 	if err != nil {
 		return err
 	}
+	db.SetMaxOpenConns(1)
+	// Opening the writer first switches the file to WAL mode, which the
+	// read-only connections can't do
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return err
+	}
+
+	DB, ReadDB = db, db
+	dataSource = dataSourceName
+	path := FilePath()
+	if path == "" {
+		return nil
+	}
 
-	DB = db
+	readDB, err := sql.Open("sqlite", "file:"+path+"?mode=ro&"+busyTimeout)
+	if err != nil {
+		_ = db.Close()
+		return err
+	}
+	conns := max(4, runtime.GOMAXPROCS(0))
+	readDB.SetMaxOpenConns(conns)
+	readDB.SetMaxIdleConns(conns)
+	ReadDB = readDB
 	return nil
 }
 
-// InitializeDatabase creates the people table if it doesn't exist
-func InitializeDatabase() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS people (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		first_name TEXT NOT NULL,
-		last_name TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
-	);`
+// Open opens another read-write pool on the database, for work that must
+// not queue behind the writer, such as WAL replication holding the write
+// lock while it reads the WAL
+func Open() (*sql.DB, error) {
+	return sql.Open("sqlite", withParams(dataSource, busyTimeout))
+}
 
-	_, err := DB.Exec(query)
-	if err != nil {
+// withParams appends query parameters to a data source name
+func withParams(dataSourceName string, params ...string) string {
+	sep := "?"
+	if strings.Contains(dataSourceName, "?") {
+		sep = "&"
+	}
+	return dataSourceName + sep + strings.Join(params, "&")
+}
+
+// FilePath returns the path of the database file, without any "file:" URI
+// prefix or query parameters; it is empty for in-memory databases
+func FilePath() string {
+	path := strings.TrimPrefix(dataSource, "file:")
+	path, _, _ = strings.Cut(path, "?")
+	if path == ":memory:" {
+		return ""
+	}
+	return path
+}
+
+// CloseDatabase checkpoints the write-ahead log into the database file and
+// closes the connection pools
+func CloseDatabase() error {
+	if err := LeaveMaintenance(context.Background()); err != nil {
+		slog.Warn("Closing the read-only connections failed", "error", err)
+	}
+	if err := closeRateLimits(context.Background()); err != nil {
+		slog.Warn("Saving rate limit buckets failed", "error", err)
+	}
+	var err error
+	if ReadDB != DB {
+		err = ReadDB.Close()
+	}
+	if _, err := DB.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		slog.Warn("WAL checkpoint failed", "error", err)
+	}
+	return errors.Join(err, DB.Close())
+}
+
+// InitializeDatabase migrates the schema to the current version
+func InitializeDatabase() error {
+	if err := migrate(); err != nil {
 		return err
 	}
 
 	// Insert some sample data if table is empty
 	var count int
-	err = DB.QueryRow("SELECT COUNT(*) FROM people").Scan(&count)
+	err := DB.QueryRow("SELECT COUNT(*) FROM people").Scan(&count)
 	if err != nil {
-		log.Printf("Warning: Could not check table count: %v", err)
+		slog.Warn("Could not check table count", "error", err)
 		return nil // Don't fail initialization if we can't check count
 	}
 
 	if count == 0 {
-		log.Println("Initializing database with sample data...")
+		slog.Info("Initializing database with sample data")
 		// Use INSERT OR IGNORE to avoid duplicate email constraint errors
 		sampleQueries := []string{
-			"INSERT OR IGNORE INTO people (first_name, last_name, email) VALUES ('John', 'Doe', 'john.doe@example.com')",
-			"INSERT OR IGNORE INTO people (first_name, last_name, email) VALUES ('Jane', 'Smith', 'jane.smith@example.com')",
-			"INSERT OR IGNORE INTO people (first_name, last_name, email) VALUES ('Bob', 'Johnson', 'bob.johnson@example.com')",
+			"INSERT OR IGNORE INTO people (first_name, last_name, email, created_at, updated_at) VALUES ('John', 'Doe', 'john.doe@example.com', ?, ?)",
+			"INSERT OR IGNORE INTO people (first_name, last_name, email, created_at, updated_at) VALUES ('Jane', 'Smith', 'jane.smith@example.com', ?, ?)",
+			"INSERT OR IGNORE INTO people (first_name, last_name, email, created_at, updated_at) VALUES ('Bob', 'Johnson', 'bob.johnson@example.com', ?, ?)",
 		}
 
 		for _, query := range sampleQueries {
-			_, err := DB.Exec(query)
+			now := time.Now().UnixNano()
+			_, err := DB.Exec(query, now, now)
 			if err != nil {
-				log.Printf("Warning: Error adding sample data: %v", err)
+				slog.Warn("Error adding sample data", "error", err)
 			}
 		}
-		log.Println("Sample data initialization completed")
+		slog.Info("Sample data initialization completed")
 	} else {
-		log.Printf("Database already contains %d records, skipping sample data initialization", count)
+		slog.Info("Database already contains records, skipping sample data initialization", "count", count)
 	}
 
 	return nil
 }
 
-// DbGetPersonsCount returns the total count of persons in the database
-func DbGetPersonsCount() (int64, error) {
+// PersonFilter narrows down person listings; the zero value lists the
+// persons that weren't deleted
+type PersonFilter struct {
+	// IncludeDeleted also lists soft deleted persons
+	IncludeDeleted bool
+	// UpdatedSince, unless zero, only lists the persons changed at or after it
+	UpdatedSince time.Time
+}
+
+// where returns the WHERE clause selecting the persons f lets through
+func (f PersonFilter) where() (string, []any) {
+	var conditions []string
+	var args []any
+	if !f.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	if !f.UpdatedSince.IsZero() {
+		conditions = append(conditions, "updated_at >= ?")
+		args = append(args, f.UpdatedSince.UnixNano())
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// DbGetPersonsCount returns the count of persons matching filter
+func DbGetPersonsCount(ctx context.Context, filter PersonFilter) (int64, error) {
+	where, args := filter.where()
+	query := "SELECT COUNT(*) FROM people" + where
+	ctx, done := startQuery(ctx, "count_persons", query)
+	defer done()
+
+	db, release := reader()
+	defer release()
+
 	var count int64
-	err := DB.QueryRow("SELECT COUNT(*) FROM people").Scan(&count)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&count)
 	return count, err
 }
 
-// DbGetPersons retrieves persons with pagination support
-func DbGetPersons(limit, offset int) ([]models.Person, error) {
-	query := "SELECT id, first_name, last_name, email FROM people ORDER BY id LIMIT ? OFFSET ?"
-	rows, err := DB.Query(query, limit, offset)
+// DbGetPersons retrieves persons matching filter with pagination support,
+// selecting only the given fields (all of them when empty); the ID is always
+// selected
+func DbGetPersons(ctx context.Context, filter PersonFilter, limit, offset int, fields []string) ([]models.Person, error) {
+	columns, err := personColumns(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := filter.where()
+	query := "SELECT " + strings.Join(columns, ", ") + " FROM people" + where + " ORDER BY id LIMIT ? OFFSET ?"
+	ctx, done := startQuery(ctx, "get_persons", query)
+	defer done()
+
+	db, release := reader()
+	defer release()
+
+	rows, err := db.QueryContext(ctx, query, append(args, limit, offset)...)
 
 	if err != nil {
 		return nil, err
@@ -92,7 +227,7 @@ func DbGetPersons(limit, offset int) ([]models.Person, error) {
 
 	for rows.Next() {
 		singlePerson := models.Person{}
-		err = rows.Scan(&singlePerson.ID, &singlePerson.FirstName, &singlePerson.LastName, &singlePerson.Email)
+		err = rows.Scan(personFieldPointers(&singlePerson, columns)...)
 
 		if err != nil {
 			return nil, err
@@ -110,45 +245,78 @@ func DbGetPersons(limit, offset int) ([]models.Person, error) {
 	return people, err
 }
 
-// DbAddPerson is ...
-func DbAddPerson(newPerson models.Person) (bool, error) {
+// DbGetAllPersons retrieves every person that wasn't deleted, ordered by ID
+func DbGetAllPersons(ctx context.Context) ([]models.Person, error) {
+	// A negative LIMIT means no upper bound in SQLite
+	return DbGetPersons(ctx, PersonFilter{}, -1, 0, nil)
+}
+
+// DbAddPerson inserts a person created by the given user, returning it with
+// its ID, creation time and creator set
+func DbAddPerson(ctx context.Context, newPerson models.Person, by string) (models.Person, error) {
+	const query = "INSERT INTO people (first_name, last_name, email, created_at, updated_at, created_by, updated_by) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	ctx, done := startQuery(ctx, "add_person", query)
+	defer done()
 
-	tx, err := DB.Begin()
+	tx, err := DB.BeginTx(ctx, nil)
 	if err != nil {
-		return false, err
+		return models.Person{}, err
 	}
+	// Release the connection's write lock if we bail out before committing
+	defer func() { _ = tx.Rollback() }()
 
-	stmt, err := tx.Prepare("INSERT INTO people (first_name, last_name, email) VALUES (?, ?, ?)")
+	stmt, err := tx.PrepareContext(ctx, query)
 
 	if err != nil {
-		return false, err
+		return models.Person{}, err
 	}
 
 	defer stmt.Close()
 
-	_, err = stmt.Exec(newPerson.FirstName, newPerson.LastName, newPerson.Email)
+	now := time.Now().UTC()
+	result, err := stmt.ExecContext(ctx, newPerson.FirstName, newPerson.LastName, newPerson.Email, now.UnixNano(), now.UnixNano(), by, by)
 
 	if err != nil {
-		return false, err
+		return models.Person{}, wrapConstraintError(err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return models.Person{}, err
 	}
 
 	if err := tx.Commit(); err != nil {
-		log.Fatal(err)
+		return models.Person{}, err
 	}
 
-	return true, nil
+	return models.Person{
+		ID:        uint64(id),
+		FirstName: newPerson.FirstName,
+		LastName:  newPerson.LastName,
+		Email:     newPerson.Email,
+		CreatedAt: now,
+		UpdatedAt: now,
+		CreatedBy: by,
+		UpdatedBy: by,
+	}, nil
 }
 
-// DbDeletePerson is ...
-func DbDeletePerson(personID int) (bool, error) {
+// DbDeletePerson soft deletes a person on behalf of the given user, hiding it
+// until it is restored or purged; it reports false when no person that
+// wasn't deleted has the ID
+func DbDeletePerson(ctx context.Context, personID int, by string) (bool, error) {
+	const query = "UPDATE people SET deleted_at = ?, updated_at = ?, updated_by = ? WHERE id = ? AND deleted_at IS NULL"
+	ctx, done := startQuery(ctx, "delete_person", query)
+	defer done()
 
-	tx, err := DB.Begin()
+	tx, err := DB.BeginTx(ctx, nil)
 
 	if err != nil {
 		return false, err
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	stmt, err := DB.Prepare("DELETE from people where id = ?")
+	stmt, err := tx.PrepareContext(ctx, query)
 
 	if err != nil {
 		return false, err
@@ -156,28 +324,39 @@ func DbDeletePerson(personID int) (bool, error) {
 
 	defer stmt.Close()
 
-	_, err = stmt.Exec(personID)
+	now := time.Now().UnixNano()
+	result, err := stmt.ExecContext(ctx, now, now, by, personID)
 
 	if err != nil {
 		return false, err
 	}
 
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
 	if err := tx.Commit(); err != nil {
-		log.Fatal(err)
+		return false, err
 	}
 
-	return true, nil
+	return affected > 0, nil
 }
 
-// DbUpdatePerson is ...
-func DbUpdatePerson(ourPerson models.Person, id int) (bool, error) {
+// DbUpdatePerson updates a person on behalf of the given user, reporting
+// false when no person that wasn't deleted has the ID
+func DbUpdatePerson(ctx context.Context, ourPerson models.Person, id int, by string) (bool, error) {
+	const query = "UPDATE people SET first_name = ?, last_name = ?, email = ?, updated_at = ?, updated_by = ? WHERE id = ? AND deleted_at IS NULL"
+	ctx, done := startQuery(ctx, "update_person", query)
+	defer done()
 
-	tx, err := DB.Begin()
+	tx, err := DB.BeginTx(ctx, nil)
 	if err != nil {
 		return false, err
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	stmt, err := tx.Prepare("UPDATE people SET first_name = ?, last_name = ?, email = ? WHERE id = ?")
+	stmt, err := tx.PrepareContext(ctx, query)
 
 	if err != nil {
 		return false, err
@@ -185,29 +364,82 @@ func DbUpdatePerson(ourPerson models.Person, id int) (bool, error) {
 
 	defer stmt.Close()
 
-	_, err = stmt.Exec(ourPerson.FirstName, ourPerson.LastName, ourPerson.Email, id)
+	result, err := stmt.ExecContext(ctx, ourPerson.FirstName, ourPerson.LastName, ourPerson.Email, time.Now().UnixNano(), by, id)
+
+	if err != nil {
+		return false, wrapConstraintError(err)
+	}
 
+	affected, err := result.RowsAffected()
 	if err != nil {
 		return false, err
 	}
 
 	if err := tx.Commit(); err != nil {
-		log.Fatal(err)
+		return false, err
 	}
 
-	return true, nil
+	return affected > 0, nil
 }
 
-// DbGetPersonByID is ...
-func DbGetPersonByID(id string) (models.Person, error) {
-	stmt, err := DB.Prepare("SELECT id, first_name, last_name, email from people WHERE id = ?")
+// DbRestorePerson undoes the soft deletion of a person on behalf of the
+// given user, reporting false when no deleted person has the ID. It fails
+// with ErrDuplicate when the email was taken meanwhile.
+func DbRestorePerson(ctx context.Context, id int, by string) (bool, error) {
+	const query = "UPDATE people SET deleted_at = NULL, updated_at = ?, updated_by = ? WHERE id = ? AND deleted_at IS NOT NULL"
+	ctx, done := startQuery(ctx, "restore_person", query)
+	defer done()
+
+	result, err := DB.ExecContext(ctx, query, time.Now().UnixNano(), by, id)
+	if err != nil {
+		return false, wrapConstraintError(err)
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// DbPurgeDeletedPersons permanently removes the persons deleted before
+// before, returning how many there were
+func DbPurgeDeletedPersons(ctx context.Context, before time.Time) (int64, error) {
+	const query = "DELETE FROM people WHERE deleted_at < ?"
+	ctx, done := startQuery(ctx, "purge_deleted_persons", query)
+	defer done()
+
+	result, err := DB.ExecContext(ctx, query, before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DbGetPersonByID retrieves a person by ID, selecting only the given fields
+// (all of them when empty); a zero ID in the result means no match. Deleted
+// persons only match with includeDeleted.
+func DbGetPersonByID(ctx context.Context, id string, fields []string, includeDeleted bool) (models.Person, error) {
+	columns, err := personColumns(fields)
+	if err != nil {
+		return models.Person{}, err
+	}
+
+	query := "SELECT " + strings.Join(columns, ", ") + " from people WHERE id = ?"
+	if !includeDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+	ctx, done := startQuery(ctx, "get_person_by_id", query)
+	defer done()
+
+	db, release := reader()
+	defer release()
+
+	stmt, err := db.PrepareContext(ctx, query)
 
 	if err != nil {
 		return models.Person{}, err
 	}
+	defer stmt.Close()
 
 	person := models.Person{}
-	sqlErr := stmt.QueryRow(id).Scan(&person.ID, &person.FirstName, &person.LastName, &person.Email)
+	sqlErr := stmt.QueryRowContext(ctx, id).Scan(personFieldPointers(&person, columns)...)
 	if sqlErr != nil {
 		if sqlErr == sql.ErrNoRows {
 			return models.Person{}, nil
@@ -216,3 +448,92 @@ func DbGetPersonByID(id string) (models.Person, error) {
 	}
 	return person, nil
 }
+
+// personColumns maps requested fields to people columns, always leading with id
+func personColumns(fields []string) ([]string, error) {
+	if len(fields) == 0 {
+		return models.PersonFields, nil
+	}
+
+	columns := []string{"id"}
+	for _, field := range fields {
+		if !slices.Contains(models.PersonFields, field) {
+			return nil, fmt.Errorf("unknown person field %q", field)
+		}
+		if field != "id" {
+			columns = append(columns, field)
+		}
+	}
+	return columns, nil
+}
+
+// personFieldPointers returns scan destinations in p for the given columns
+func personFieldPointers(p *models.Person, columns []string) []any {
+	dest := make([]any, len(columns))
+	for i, column := range columns {
+		switch column {
+		case "id":
+			dest[i] = &p.ID
+		case "first_name":
+			dest[i] = &p.FirstName
+		case "last_name":
+			dest[i] = &p.LastName
+		case "email":
+			dest[i] = &p.Email
+		case "deleted_at":
+			dest[i] = nullTime{&p.DeletedAt}
+		case "created_at":
+			dest[i] = unixTime{&p.CreatedAt}
+		case "updated_at":
+			dest[i] = unixTime{&p.UpdatedAt}
+		case "created_by":
+			dest[i] = &p.CreatedBy
+		case "updated_by":
+			dest[i] = &p.UpdatedBy
+		}
+	}
+	return dest
+}
+
+// nullTime scans a nullable column of Unix nanoseconds into a time pointer
+type nullTime struct {
+	t **time.Time
+}
+
+// Scan implements sql.Scanner
+func (n nullTime) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*n.t = nil
+	case int64:
+		t := time.Unix(0, v).UTC()
+		*n.t = &t
+	default:
+		return fmt.Errorf("cannot scan %T into a time", src)
+	}
+	return nil
+}
+
+// unixTime scans a column of Unix nanoseconds into a time
+type unixTime struct {
+	t *time.Time
+}
+
+// Scan implements sql.Scanner
+func (u unixTime) Scan(src any) error {
+	v, ok := src.(int64)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into a time", src)
+	}
+	*u.t = time.Unix(0, v).UTC()
+	return nil
+}
+
+// wrapConstraintError marks unique constraint violations with ErrDuplicate
+func wrapConstraintError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		return fmt.Errorf("%w: %v", ErrDuplicate, err)
+	}
+	return err
+}
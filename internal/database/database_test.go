@@ -0,0 +1,248 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/atrakic/gin-sqlite/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func setupDatabase(tb testing.TB) {
+	require.NoError(tb, ConnectDatabase(filepath.Join(tb.TempDir(), "test.db")))
+	tb.Cleanup(func() { _ = CloseDatabase() })
+	require.NoError(tb, InitializeDatabase())
+}
+
+// isBusy reports whether err is SQLite failing to get a lock
+func isBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// mixedLoad runs the operations of a mixed workload, two writes to
+// three reads, counting them and the busy errors
+type mixedLoad struct {
+	n                   atomic.Int64
+	reads, writes, busy atomic.Int64
+}
+
+// op runs the next operation
+func (l *mixedLoad) op(ctx context.Context) error {
+	n := l.n.Add(1)
+	var err error
+	switch n % 5 {
+	case 0:
+		_, err = DbAddPerson(ctx, models.Person{FirstName: "Load", LastName: "Test", Email: fmt.Sprintf("load%d@example.com", n)}, "load")
+		l.writes.Add(1)
+	case 1:
+		_, err = DbUpdatePerson(ctx, models.Person{FirstName: "John", LastName: strconv.FormatInt(n, 10), Email: "john.doe@example.com"}, 1, "load")
+		l.writes.Add(1)
+	case 2, 3:
+		_, err = DbGetPersonByID(ctx, strconv.FormatInt(n%3+1, 10), nil, false)
+		l.reads.Add(1)
+	default:
+		_, err = DbGetPersons(ctx, PersonFilter{}, 10, 0, nil)
+		l.reads.Add(1)
+	}
+	if isBusy(err) {
+		l.busy.Add(1)
+	}
+	return err
+}
+
+func TestMixedLoadWithoutBusyErrors(t *testing.T) {
+	setupDatabase(t)
+	ctx := t.Context()
+
+	var load mixedLoad
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if err := load.op(ctx); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Zero(t, load.busy.Load())
+	assert.Equal(t, int64(32*50), load.reads.Load()+load.writes.Load())
+
+	count, err := DbGetPersonsCount(ctx, PersonFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3+32*50/5), count, "every insert committed")
+}
+
+// BenchmarkMixedLoad measures the throughput of concurrent reads and writes,
+// run with -cpu to vary the number of goroutines, e.g.
+//
+//	go test ./internal/database -run '^$' -bench MixedLoad -cpu 1,4,16
+func BenchmarkMixedLoad(b *testing.B) {
+	setupDatabase(b)
+	ctx := b.Context()
+
+	var load mixedLoad
+	var failed atomic.Int64
+	b.SetParallelism(4)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := load.op(ctx); err != nil {
+				failed.Add(1)
+			}
+		}
+	})
+
+	seconds := b.Elapsed().Seconds()
+	b.ReportMetric(float64(load.reads.Load())/seconds, "reads/s")
+	b.ReportMetric(float64(load.writes.Load())/seconds, "writes/s")
+	b.ReportMetric(float64(load.busy.Load()), "busy-errors")
+	if failed.Load() > 0 {
+		b.Fatalf("%d operations failed, %d of them busy", failed.Load(), load.busy.Load())
+	}
+}
+
+func TestSoftDeleteMigrationKeepsIDs(t *testing.T) {
+	require.NoError(t, ConnectDatabase(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { _ = CloseDatabase() })
+
+	// A database from before soft deletes, whose last person was deleted
+	for i, migration := range migrations[:3] {
+		_, err := DB.Exec(migration)
+		require.NoError(t, err)
+		_, err = DB.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1))
+		require.NoError(t, err)
+	}
+	_, err := DB.Exec(`INSERT INTO people (first_name, last_name, email) VALUES
+		('John', 'Doe', 'john.doe@example.com'), ('Jane', 'Smith', 'jane.smith@example.com');
+		DELETE FROM people WHERE id = 2`)
+	require.NoError(t, err)
+
+	require.NoError(t, InitializeDatabase())
+	person, err := DbAddPerson(t.Context(), models.Person{FirstName: "Bob", LastName: "Johnson", Email: "bob.johnson@example.com"}, "test")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), person.ID, "IDs are not reused")
+
+	person, err = DbGetPersonByID(t.Context(), "1", nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, "john.doe@example.com", person.Email)
+	assert.WithinDuration(t, time.Now(), person.CreatedAt, time.Minute, "backfilled from the history")
+	assert.Equal(t, person.CreatedAt, person.UpdatedAt)
+
+	changes, err := DbGetPersonChanges(t.Context(), 0, 10)
+	require.NoError(t, err)
+	require.Len(t, changes, 2, "the existing person, then the new one")
+	assert.Equal(t, uint64(1), changes[0].PersonID)
+	assert.Equal(t, "insert", changes[0].Operation)
+	assert.Equal(t, uint64(3), changes[1].PersonID)
+
+	_, err = DbAddPerson(t.Context(), models.Person{FirstName: "John", LastName: "Again", Email: "john.doe@example.com"}, "test")
+	assert.ErrorIs(t, err, ErrDuplicate)
+}
+
+func TestLeaveMaintenanceWaitsForReads(t *testing.T) {
+	setupDatabase(t)
+	ctx := context.Background()
+	require.NoError(t, EnterMaintenance(ctx, time.Minute))
+
+	db, release := reader()
+	rows, err := db.QueryContext(ctx, "SELECT id FROM people ORDER BY id")
+	require.NoError(t, err)
+
+	left := make(chan error, 1)
+	go func() { left <- LeaveMaintenance(ctx) }()
+	select {
+	case err := <-left:
+		t.Fatalf("left maintenance mode during a read: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The pool is still open for the read under way
+	var ids []int
+	for rows.Next() {
+		var id int
+		require.NoError(t, rows.Scan(&id))
+		ids = append(ids, id)
+	}
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+	assert.Equal(t, []int{1, 2, 3}, ids)
+	release()
+
+	require.NoError(t, <-left)
+	db, release = reader()
+	defer release()
+	assert.Same(t, ReadDB, db)
+	persons, err := DbGetPersons(ctx, PersonFilter{}, 10, 0, nil)
+	require.NoError(t, err)
+	assert.Len(t, persons, 3)
+}
+
+func TestRateLimitStoreFlush(t *testing.T) {
+	setupDatabase(t)
+	ctx := context.Background()
+	store := NewRateLimitStore()
+	now := time.Now()
+
+	saved := func() int {
+		var n int
+		require.NoError(t, DB.QueryRow("SELECT COUNT(*) FROM rate_limit_buckets").Scan(&n))
+		return n
+	}
+	take := func(b ratelimit.Bucket, found bool) ratelimit.Bucket {
+		if !found {
+			b.Tokens = 10
+		}
+		return ratelimit.Bucket{Tokens: b.Tokens - 1, Updated: now, FullAt: now.Add(time.Minute)}
+	}
+
+	require.NoError(t, store.Update(ctx, "a", take))
+	require.NoError(t, store.Update(ctx, "a", take))
+	assert.Zero(t, saved(), "updates stay in memory until flushed")
+
+	// Nothing is written in maintenance mode, the buckets wait for a later flush
+	require.NoError(t, EnterMaintenance(ctx, time.Minute))
+	require.NoError(t, store.Update(ctx, "b", take))
+	require.NoError(t, store.Flush(ctx))
+	require.NoError(t, store.Prune(ctx, now))
+	assert.Zero(t, saved())
+	require.NoError(t, LeaveMaintenance(ctx))
+
+	require.NoError(t, store.Flush(ctx))
+	assert.Equal(t, 2, saved())
+
+	// Another store loads the saved bucket
+	var tokens float64
+	require.NoError(t, NewRateLimitStore().Update(ctx, "a", func(b ratelimit.Bucket, found bool) ratelimit.Bucket {
+		assert.True(t, found)
+		tokens = b.Tokens
+		return b
+	}))
+	assert.InDelta(t, 8, tokens, 0)
+}
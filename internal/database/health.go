@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/health"
+)
+
+// HealthChecks returns the readiness checks for the database: connectivity,
+// schema version, free disk space next to the database file, WAL size and
+// maintenance mode. Maintenance mode passes, as reads are still served.
+func HealthChecks(minDiskFree, maxWALSize uint64) []health.Check {
+	return []health.Check{
+		{Name: "database", Run: func(ctx context.Context) (string, error) {
+			return "", errors.Join(DB.PingContext(ctx), ReadDB.PingContext(ctx))
+		}},
+		{Name: "schema_version", Run: func(ctx context.Context) (string, error) {
+			version, err := GetSchemaVersion(ctx)
+			if err != nil {
+				return "", err
+			}
+			if version != SchemaVersion {
+				return "", fmt.Errorf("schema version %d, expected %d", version, SchemaVersion)
+			}
+			return fmt.Sprintf("version %d", version), nil
+		}},
+		{Name: "disk_space", Run: func(ctx context.Context) (string, error) {
+			path := FilePath()
+			if path == "" {
+				return "in-memory database", nil
+			}
+			free, err := health.DiskFree(filepath.Dir(path))
+			if errors.Is(err, errors.ErrUnsupported) {
+				return "not supported on this platform", nil
+			}
+			if err != nil {
+				return "", err
+			}
+			if free < minDiskFree {
+				return "", fmt.Errorf("%d bytes free, below minimum of %d", free, minDiskFree)
+			}
+			return fmt.Sprintf("%d bytes free", free), nil
+		}},
+		{Name: "wal_size", Run: func(ctx context.Context) (string, error) {
+			size, err := WALSize()
+			if err != nil {
+				return "", err
+			}
+			if maxWALSize > 0 && size > maxWALSize {
+				return "", fmt.Errorf("WAL is %d bytes, above maximum of %d", size, maxWALSize)
+			}
+			return fmt.Sprintf("%d bytes", size), nil
+		}},
+		{Name: "maintenance", Run: func(ctx context.Context) (string, error) {
+			status := MaintenanceStatus()
+			if !status.Enabled {
+				return "off", nil
+			}
+			return "read-only since " + status.Since.Format(time.RFC3339), nil
+		}},
+	}
+}
+
+// WALSize returns the size of the write-ahead log file, zero when there is none
+func WALSize() (uint64, error) {
+	path := FilePath()
+	if path == "" {
+		return 0, nil
+	}
+
+	info, err := os.Stat(path + "-wal")
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return uint64(info.Size()), nil
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/models"
+)
+
+// revisionColumns are the columns of people_history, aliased h, scanned by
+// scanRevision
+const revisionColumns = "h.revision, h.person_id, h.operation, h.first_name, h.last_name, h.email, h.deleted_at, h.changed_at, h.changed_by"
+
+// personVisible is the condition that the person with the ID bound to it
+// exists and, unless the bound flag is set, wasn't deleted
+const personVisible = "EXISTS (SELECT 1 FROM people WHERE id = ? AND (? OR deleted_at IS NULL))"
+
+// scanRevision scans a row of revisionColumns followed by extra columns
+func scanRevision(row interface{ Scan(...any) error }, extra ...any) (models.PersonRevision, error) {
+	var r models.PersonRevision
+	dest := []any{&r.Revision, &r.PersonID, &r.Operation, &r.FirstName, &r.LastName, &r.Email, nullTime{&r.DeletedAt}, unixTime{&r.ChangedAt}, &r.ChangedBy}
+	err := row.Scan(append(dest, extra...)...)
+	return r, err
+}
+
+// DbGetPersonHistory returns the revisions of a person, oldest first; it is
+// empty when no person has the ID, or when the person was deleted unless
+// includeDeleted is set
+func DbGetPersonHistory(ctx context.Context, id int, includeDeleted bool) ([]models.PersonRevision, error) {
+	const query = "SELECT " + revisionColumns + " FROM people_history h WHERE h.person_id = ? AND " + personVisible + " ORDER BY h.revision"
+	ctx, done := startQuery(ctx, "get_person_history", query)
+	defer done()
+
+	db, release := reader()
+	defer release()
+
+	rows, err := db.QueryContext(ctx, query, id, id, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]models.PersonRevision, 0)
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// DbGetPersonAsOf returns a person as it was at the given time, with a zero
+// ID when it didn't exist or was deleted then. Persons deleted since are
+// only found with includeDeleted.
+func DbGetPersonAsOf(ctx context.Context, id int, at time.Time, includeDeleted bool) (models.Person, error) {
+	const query = "SELECT " + revisionColumns + ", p.created_at, p.created_by FROM people_history h JOIN people p ON p.id = h.person_id" +
+		" WHERE h.person_id = ? AND h.changed_at <= ? AND " + personVisible + " ORDER BY h.revision DESC LIMIT 1"
+	ctx, done := startQuery(ctx, "get_person_as_of", query)
+	defer done()
+
+	db, release := reader()
+	defer release()
+
+	var createdAt time.Time
+	var createdBy string
+	r, err := scanRevision(db.QueryRowContext(ctx, query, id, at.UnixNano(), id, includeDeleted), unixTime{&createdAt}, &createdBy)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && r.DeletedAt != nil) {
+		return models.Person{}, nil
+	}
+	if err != nil {
+		return models.Person{}, err
+	}
+	person := r.Person()
+	person.CreatedAt, person.CreatedBy = createdAt, createdBy
+	return person, nil
+}
+
+// DbRevertPerson sets a person back to the data of one of its revisions on
+// behalf of the given user, restoring it when it was deleted. It reports
+// false when the person has no such revision, and fails with ErrDuplicate
+// when the email was taken meanwhile.
+func DbRevertPerson(ctx context.Context, id int, revision int64, by string) (bool, error) {
+	const query = `UPDATE people SET deleted_at = NULL, updated_at = ?, updated_by = ?, (first_name, last_name, email) =
+		(SELECT first_name, last_name, email FROM people_history WHERE revision = ? AND person_id = people.id)
+		WHERE id = ? AND EXISTS (SELECT 1 FROM people_history WHERE revision = ? AND person_id = people.id)`
+	ctx, done := startQuery(ctx, "revert_person", query)
+	defer done()
+
+	result, err := DB.ExecContext(ctx, query, time.Now().UnixNano(), by, revision, id, revision)
+	if err != nil {
+		return false, wrapConstraintError(err)
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
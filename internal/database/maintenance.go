@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/models"
+)
+
+// maxJobRuns is how many job runs the history keeps
+const maxJobRuns = 1000
+
+// ErrCheckpointBusy is returned when readers or writers kept a checkpoint
+// from completing
+var ErrCheckpointBusy = errors.New("checkpoint blocked by other connections")
+
+// Checkpoint copies the write-ahead log into the database file and truncates
+// it, returning the number of frames it held
+func Checkpoint(ctx context.Context) (int, error) {
+	// The frame count is gone once the WAL is truncated
+	var busy, frames, checkpointed int
+	ctx, done := startQuery(ctx, "checkpoint", "PRAGMA wal_checkpoint(PASSIVE)")
+	err := DB.QueryRowContext(ctx, "PRAGMA wal_checkpoint(PASSIVE)").Scan(&busy, &frames, &checkpointed)
+	done()
+	if err != nil {
+		return 0, err
+	}
+
+	const query = "PRAGMA wal_checkpoint(TRUNCATE)"
+	ctx, done = startQuery(ctx, "checkpoint", query)
+	defer done()
+	var log int
+	if err := DB.QueryRowContext(ctx, query).Scan(&busy, &log, &checkpointed); err != nil {
+		return 0, err
+	}
+	if busy != 0 {
+		return frames, ErrCheckpointBusy
+	}
+	return frames, nil
+}
+
+// IncrementalVacuum returns the free pages of the database file to the file
+// system and returns how many there were. A database not in incremental
+// auto vacuum mode is switched to it first with a full VACUUM.
+func IncrementalVacuum(ctx context.Context) (freed int64, switched bool, err error) {
+	var mode int
+	if err := DB.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return 0, false, err
+	}
+	// 2 is incremental; the mode only changes with a VACUUM
+	if mode != 2 {
+		const query = "PRAGMA auto_vacuum = INCREMENTAL; VACUUM"
+		ctx, done := startQuery(ctx, "vacuum", query)
+		_, err := DB.ExecContext(ctx, query)
+		done()
+		if err != nil {
+			return 0, false, err
+		}
+		switched = true
+	}
+
+	if err := DB.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&freed); err != nil {
+		return 0, switched, err
+	}
+
+	const query = "PRAGMA incremental_vacuum"
+	ctx, done := startQuery(ctx, "incremental_vacuum", query)
+	defer done()
+
+	// Each step frees a page, so the statement must run to completion
+	rows, err := DB.QueryContext(ctx, query)
+	if err != nil {
+		return 0, switched, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return freed, switched, rows.Err()
+}
+
+// Optimize runs PRAGMA optimize on every table, which analyzes those whose
+// statistics are missing or out of date
+func Optimize(ctx context.Context) error {
+	const query = "PRAGMA optimize = 0x10002"
+	ctx, done := startQuery(ctx, "optimize", query)
+	defer done()
+
+	_, err := DB.ExecContext(ctx, query)
+	return err
+}
+
+// IntegrityCheck runs PRAGMA integrity_check and returns the problems found
+func IntegrityCheck(ctx context.Context) ([]string, error) {
+	const query = "PRAGMA integrity_check"
+	ctx, done := startQuery(ctx, "integrity_check", query)
+	defer done()
+
+	rows, err := ReadDB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return nil, err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// DbAddJobRun records a maintenance job run, keeping the latest maxJobRuns
+func DbAddJobRun(ctx context.Context, run models.JobRun) error {
+	const query = "INSERT INTO job_runs (job, started_at, duration, status, detail) VALUES (?, ?, ?, ?, ?)"
+	ctx, done := startQuery(ctx, "add_job_run", query)
+	defer done()
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, query, run.Job, run.Started.UnixNano(), int64(run.Duration), run.Status, run.Detail)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM job_runs WHERE id <= ?", id-maxJobRuns); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DbGetJobRuns returns up to limit runs, newest first, of the named job or
+// of all jobs when job is empty
+func DbGetJobRuns(ctx context.Context, job string, limit int) ([]models.JobRun, error) {
+	const query = `SELECT id, job, started_at, duration, status, detail FROM job_runs
+		WHERE ? = '' OR job = ? ORDER BY id DESC LIMIT ?`
+	ctx, done := startQuery(ctx, "get_job_runs", query)
+	defer done()
+
+	db, release := reader()
+	defer release()
+
+	rows, err := db.QueryContext(ctx, query, job, job, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := make([]models.JobRun, 0)
+	for rows.Next() {
+		var run models.JobRun
+		var started, duration int64
+		if err := rows.Scan(&run.ID, &run.Job, &started, &duration, &run.Status, &run.Detail); err != nil {
+			return nil, err
+		}
+		run.Started = time.Unix(0, started).UTC()
+		run.Duration = time.Duration(duration)
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
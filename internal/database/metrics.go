@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+
+	"github.com/atrakic/gin-sqlite/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		// DB is read at scrape time since it is replaced on reconnect
+		metrics.NewDBStatsCollector("sqlite", func() *sql.DB { return DB }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "db_rows",
+			Help:        "Number of rows per table.",
+			ConstLabels: prometheus.Labels{"table": "people"},
+		}, peopleRows),
+	)
+}
+
+// peopleRows counts the people table for the db_rows gauge
+func peopleRows() float64 {
+	if DB == nil {
+		return 0
+	}
+	count, err := DbGetPersonsCount(context.Background(), PersonFilter{})
+	if err != nil {
+		slog.Warn("Could not count people for metrics", "error", err)
+		return 0
+	}
+	return float64(count)
+}
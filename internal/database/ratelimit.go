@@ -0,0 +1,212 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/ratelimit"
+)
+
+// rateLimitFlushInterval is how often the buckets changed in memory are
+// written to the rate_limit_buckets table
+const rateLimitFlushInterval = 5 * time.Second
+
+// RateLimitStore keeps rate limit buckets in the rate_limit_buckets table, so
+// limits survive restarts. Requests update the buckets in memory and those
+// changed are written in a single transaction at most once per
+// rateLimitFlushInterval, rather than taking the writer on every request:
+// processes sharing the file only see each other's buckets for clients they
+// haven't served yet. CloseDatabase writes the buckets left.
+type RateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]ratelimit.Bucket
+	dirty     map[string]struct{}
+	nextFlush time.Time
+	flushing  bool
+}
+
+// rateLimitStores are the stores FlushRateLimits writes
+var (
+	rateLimitStoresMu sync.Mutex
+	rateLimitStores   []*RateLimitStore
+)
+
+// NewRateLimitStore creates a store with no bucket loaded yet
+func NewRateLimitStore() *RateLimitStore {
+	s := &RateLimitStore{
+		buckets:   make(map[string]ratelimit.Bucket),
+		dirty:     make(map[string]struct{}),
+		nextFlush: time.Now().Add(rateLimitFlushInterval),
+	}
+	rateLimitStoresMu.Lock()
+	rateLimitStores = append(rateLimitStores, s)
+	rateLimitStoresMu.Unlock()
+	return s
+}
+
+// FlushRateLimits writes the buckets changed since the last flush of every
+// store
+func FlushRateLimits(ctx context.Context) error {
+	rateLimitStoresMu.Lock()
+	stores := slices.Clone(rateLimitStores)
+	rateLimitStoresMu.Unlock()
+
+	var errs []error
+	for _, s := range stores {
+		errs = append(errs, s.Flush(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// closeRateLimits flushes every store and forgets them, as they can't be
+// written once the database is closed
+func closeRateLimits(ctx context.Context) error {
+	err := FlushRateLimits(ctx)
+	rateLimitStoresMu.Lock()
+	rateLimitStores = nil
+	rateLimitStoresMu.Unlock()
+	return err
+}
+
+// Update implements ratelimit.Store
+func (s *RateLimitStore) Update(ctx context.Context, key string, fn func(ratelimit.Bucket, bool) ratelimit.Bucket) error {
+	s.mu.Lock()
+	_, cached := s.buckets[key]
+	s.mu.Unlock()
+
+	// Read outside the lock the bucket saved before this process served the key
+	var stored *ratelimit.Bucket
+	if !cached {
+		b, found, err := loadBucket(ctx, key)
+		if err != nil {
+			return err
+		}
+		if found {
+			stored = &b
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, found := s.buckets[key]
+	if !found && stored != nil {
+		b, found = *stored, true
+	}
+	s.buckets[key] = fn(b, found)
+	s.dirty[key] = struct{}{}
+
+	if now := time.Now(); now.After(s.nextFlush) && !s.flushing {
+		s.nextFlush = now.Add(rateLimitFlushInterval)
+		s.flushing = true
+		go func() {
+			if err := s.Flush(context.WithoutCancel(ctx)); err != nil {
+				slog.WarnContext(ctx, "Saving rate limit buckets failed", "error", err)
+			}
+			s.mu.Lock()
+			s.flushing = false
+			s.mu.Unlock()
+		}()
+	}
+	return nil
+}
+
+// Flush writes the buckets changed since the last flush. It does nothing in
+// maintenance mode, keeping them for the first flush after it.
+func (s *RateLimitStore) Flush(ctx context.Context) error {
+	if in, _ := InMaintenance(); in {
+		return nil
+	}
+
+	s.mu.Lock()
+	changed := make(map[string]ratelimit.Bucket, len(s.dirty))
+	for key := range s.dirty {
+		changed[key] = s.buckets[key]
+	}
+	clear(s.dirty)
+	s.mu.Unlock()
+
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := saveBuckets(ctx, changed); err != nil {
+		// Retried with the next flush
+		s.mu.Lock()
+		for key := range changed {
+			if _, ok := s.buckets[key]; ok {
+				s.dirty[key] = struct{}{}
+			}
+		}
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Prune implements ratelimit.Store
+func (s *RateLimitStore) Prune(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	for key, b := range s.buckets {
+		if !b.FullAt.After(now) {
+			delete(s.buckets, key)
+			delete(s.dirty, key)
+		}
+	}
+	s.mu.Unlock()
+
+	if in, _ := InMaintenance(); in {
+		return nil
+	}
+	_, err := DB.ExecContext(ctx, "DELETE FROM rate_limit_buckets WHERE full_at <= ?", now.UnixNano())
+	return err
+}
+
+// loadBucket reads the saved bucket of key
+func loadBucket(ctx context.Context, key string) (ratelimit.Bucket, bool, error) {
+	db, release := reader()
+	defer release()
+
+	var (
+		b               ratelimit.Bucket
+		updated, fullAt int64
+	)
+	err := db.QueryRowContext(ctx, "SELECT tokens, updated_at, full_at FROM rate_limit_buckets WHERE key = ?", key).
+		Scan(&b.Tokens, &updated, &fullAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return b, false, nil
+	}
+	if err != nil {
+		return b, false, err
+	}
+	b.Updated = time.Unix(0, updated)
+	b.FullAt = time.Unix(0, fullAt)
+	return b, true, nil
+}
+
+// saveBuckets writes buckets in a single transaction
+func saveBuckets(ctx context.Context, buckets map[string]ratelimit.Bucket) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO rate_limit_buckets (key, tokens, updated_at, full_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET tokens = excluded.tokens, updated_at = excluded.updated_at, full_at = excluded.full_at`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for key, b := range buckets {
+		if _, err := stmt.ExecContext(ctx, key, b.Tokens, b.Updated.UnixNano(), b.FullAt.UnixNano()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/models"
+)
+
+// maintenanceMode is the read-only state entered by EnterMaintenance
+type maintenanceMode struct {
+	pool       *readOnlyPool
+	since      time.Time
+	retryAfter time.Duration
+}
+
+// readOnlyPool is the connection pool reads use in maintenance mode. Reads
+// hold inUse for reading while they run, so that closing it waits for them.
+type readOnlyPool struct {
+	db     *sql.DB
+	inUse  sync.RWMutex
+	closed bool
+}
+
+// close waits for the reads still running on the pool and closes it
+func (p *readOnlyPool) close() error {
+	p.inUse.Lock()
+	defer p.inUse.Unlock()
+	p.closed = true
+	return p.db.Close()
+}
+
+var (
+	// maintenance is nil outside maintenance mode
+	maintenance atomic.Pointer[maintenanceMode]
+	// maintenanceMu serializes entering and leaving maintenance mode
+	maintenanceMu sync.Mutex
+)
+
+// EnterMaintenance puts the database in read-only maintenance mode: reads
+// move to a read-only connection pool and InMaintenance reports true, so the
+// API rejects writes, telling clients to retry after retryAfter. Entering it
+// again only updates retryAfter.
+func EnterMaintenance(ctx context.Context, retryAfter time.Duration) error {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+
+	if m := maintenance.Load(); m != nil {
+		maintenance.Store(&maintenanceMode{pool: m.pool, since: m.since, retryAfter: retryAfter})
+		return nil
+	}
+
+	path := FilePath()
+	if path == "" {
+		return errors.New("maintenance mode needs a database file")
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)&_pragma=query_only(1)")
+	if err != nil {
+		return err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return err
+	}
+
+	maintenance.Store(&maintenanceMode{pool: &readOnlyPool{db: db}, since: time.Now().UTC(), retryAfter: retryAfter})
+	slog.WarnContext(ctx, "Entered maintenance mode, rejecting writes", "retry_after", retryAfter)
+	return nil
+}
+
+// LeaveMaintenance leaves maintenance mode and closes the read-only pool
+func LeaveMaintenance(ctx context.Context) error {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+
+	m := maintenance.Swap(nil)
+	if m == nil {
+		return nil
+	}
+	slog.InfoContext(ctx, "Left maintenance mode", "duration", time.Since(m.since))
+	// New reads go to ReadDB, those that got the pool before the swap finish
+	return m.pool.close()
+}
+
+// InMaintenance reports whether the database is in maintenance mode, and if
+// so for how long clients should wait before retrying writes
+func InMaintenance() (bool, time.Duration) {
+	if m := maintenance.Load(); m != nil {
+		return true, m.retryAfter
+	}
+	return false, 0
+}
+
+// MaintenanceStatus describes the maintenance mode
+func MaintenanceStatus() models.MaintenanceStatus {
+	m := maintenance.Load()
+	if m == nil {
+		return models.MaintenanceStatus{}
+	}
+	since := m.since
+	return models.MaintenanceStatus{Enabled: true, Since: &since, RetryAfter: int(m.retryAfter / time.Second)}
+}
+
+// reader returns the pool reads go to: the one opened for maintenance mode
+// while in it, ReadDB otherwise. The read must call release once done with
+// the pool, rows included, which leaving maintenance mode waits for.
+func reader() (db *sql.DB, release func()) {
+	if m := maintenance.Load(); m != nil {
+		m.pool.inUse.RLock()
+		if !m.pool.closed {
+			return m.pool.db, m.pool.inUse.RUnlock
+		}
+		// Left maintenance mode meanwhile
+		m.pool.inUse.RUnlock()
+	}
+	return ReadDB, func() {}
+}
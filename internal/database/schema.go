@@ -0,0 +1,188 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// migrations holds the schema changes in order; the schema version stored in
+// PRAGMA user_version is the number of migrations applied. Only ever append.
+var migrations = []string{
+	// 1: people table
+	`CREATE TABLE IF NOT EXISTS people (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		first_name TEXT NOT NULL,
+		last_name TEXT NOT NULL,
+		email TEXT UNIQUE NOT NULL
+	);`,
+	// 2: rate limit buckets, times in Unix nanoseconds
+	`CREATE TABLE rate_limit_buckets (
+		key TEXT PRIMARY KEY,
+		tokens REAL NOT NULL,
+		updated_at INTEGER NOT NULL,
+		full_at INTEGER NOT NULL
+	);
+	CREATE INDEX rate_limit_buckets_full_at ON rate_limit_buckets (full_at);`,
+	// 3: maintenance job history, times in Unix nanoseconds
+	`CREATE TABLE job_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job TEXT NOT NULL,
+		started_at INTEGER NOT NULL,
+		duration INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		detail TEXT NOT NULL
+	);
+	CREATE INDEX job_runs_job ON job_runs (job, id);`,
+	// 4: soft deleted people, deleted_at in Unix nanoseconds. The table is
+	// rebuilt so emails only need to be unique among the people not deleted,
+	// keeping the AUTOINCREMENT sequence.
+	`CREATE TABLE people_new (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		first_name TEXT NOT NULL,
+		last_name TEXT NOT NULL,
+		email TEXT NOT NULL,
+		deleted_at INTEGER
+	);
+	INSERT INTO people_new (id, first_name, last_name, email) SELECT id, first_name, last_name, email FROM people;
+	DELETE FROM sqlite_sequence WHERE name = 'people_new';
+	INSERT INTO sqlite_sequence (name, seq) SELECT 'people_new', seq FROM sqlite_sequence WHERE name = 'people';
+	DROP TABLE people;
+	ALTER TABLE people_new RENAME TO people;
+	CREATE UNIQUE INDEX people_email ON people (email) WHERE deleted_at IS NULL;
+	CREATE INDEX people_deleted_at ON people (deleted_at) WHERE deleted_at IS NOT NULL;`,
+	// 5: people history, a revision per write recorded by triggers, times in
+	// Unix nanoseconds; purging a person purges its history
+	`CREATE TABLE people_history (
+		revision INTEGER PRIMARY KEY AUTOINCREMENT,
+		person_id INTEGER NOT NULL,
+		operation TEXT NOT NULL,
+		first_name TEXT NOT NULL,
+		last_name TEXT NOT NULL,
+		email TEXT NOT NULL,
+		deleted_at INTEGER,
+		changed_at INTEGER NOT NULL
+	);
+	CREATE INDEX people_history_person ON people_history (person_id, changed_at);
+	INSERT INTO people_history (person_id, operation, first_name, last_name, email, deleted_at, changed_at)
+		SELECT id, 'insert', first_name, last_name, email, deleted_at, CAST(unixepoch('subsec') * 1e9 AS INTEGER) FROM people ORDER BY id;
+	CREATE TRIGGER people_history_insert AFTER INSERT ON people BEGIN
+		INSERT INTO people_history (person_id, operation, first_name, last_name, email, deleted_at, changed_at)
+		VALUES (NEW.id, 'insert', NEW.first_name, NEW.last_name, NEW.email, NEW.deleted_at, CAST(unixepoch('subsec') * 1e9 AS INTEGER));
+	END;
+	CREATE TRIGGER people_history_update AFTER UPDATE ON people BEGIN
+		INSERT INTO people_history (person_id, operation, first_name, last_name, email, deleted_at, changed_at)
+		VALUES (NEW.id, CASE
+			WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN 'delete'
+			WHEN OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN 'restore'
+			ELSE 'update' END,
+			NEW.first_name, NEW.last_name, NEW.email, NEW.deleted_at, CAST(unixepoch('subsec') * 1e9 AS INTEGER));
+	END;
+	CREATE TRIGGER people_history_purge AFTER DELETE ON people BEGIN
+		DELETE FROM people_history WHERE person_id = OLD.id;
+	END;`,
+	// 6: who created and last changed people and when, times in Unix
+	// nanoseconds taken from the history; revisions record who made them
+	`ALTER TABLE people ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE people ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE people ADD COLUMN created_by TEXT NOT NULL DEFAULT '';
+	ALTER TABLE people ADD COLUMN updated_by TEXT NOT NULL DEFAULT '';
+	ALTER TABLE people_history ADD COLUMN changed_by TEXT NOT NULL DEFAULT '';
+	UPDATE people SET
+		created_at = (SELECT MIN(changed_at) FROM people_history WHERE person_id = people.id),
+		updated_at = (SELECT MAX(changed_at) FROM people_history WHERE person_id = people.id)
+		WHERE id IN (SELECT person_id FROM people_history);
+	CREATE INDEX people_updated_at ON people (updated_at);
+	DROP TRIGGER people_history_insert;
+	DROP TRIGGER people_history_update;
+	CREATE TRIGGER people_history_insert AFTER INSERT ON people BEGIN
+		INSERT INTO people_history (person_id, operation, first_name, last_name, email, deleted_at, changed_at, changed_by)
+		VALUES (NEW.id, 'insert', NEW.first_name, NEW.last_name, NEW.email, NEW.deleted_at, NEW.updated_at, NEW.updated_by);
+	END;
+	CREATE TRIGGER people_history_update AFTER UPDATE ON people BEGIN
+		INSERT INTO people_history (person_id, operation, first_name, last_name, email, deleted_at, changed_at, changed_by)
+		VALUES (NEW.id, CASE
+			WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN 'delete'
+			WHEN OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN 'restore'
+			ELSE 'update' END,
+			NEW.first_name, NEW.last_name, NEW.email, NEW.deleted_at, NEW.updated_at, NEW.updated_by);
+	END;`,
+	// 7: person change feed, in sequence order and kept across purges, times
+	// in Unix nanoseconds. Restores show as inserts; deletes are tombstones
+	// without the person's data. The people not deleted are fed in first.
+	`CREATE TABLE person_changes (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		person_id INTEGER NOT NULL,
+		operation TEXT NOT NULL,
+		first_name TEXT,
+		last_name TEXT,
+		email TEXT,
+		created_at INTEGER,
+		created_by TEXT,
+		changed_at INTEGER NOT NULL,
+		changed_by TEXT NOT NULL
+	);
+	INSERT INTO person_changes (person_id, operation, first_name, last_name, email, created_at, created_by, changed_at, changed_by)
+		SELECT id, 'insert', first_name, last_name, email, created_at, created_by, updated_at, updated_by FROM people WHERE deleted_at IS NULL ORDER BY id;
+	CREATE TRIGGER person_changes_insert AFTER INSERT ON people WHEN NEW.deleted_at IS NULL BEGIN
+		INSERT INTO person_changes (person_id, operation, first_name, last_name, email, created_at, created_by, changed_at, changed_by)
+		VALUES (NEW.id, 'insert', NEW.first_name, NEW.last_name, NEW.email, NEW.created_at, NEW.created_by, NEW.updated_at, NEW.updated_by);
+	END;
+	CREATE TRIGGER person_changes_update AFTER UPDATE ON people WHEN NEW.deleted_at IS NULL BEGIN
+		INSERT INTO person_changes (person_id, operation, first_name, last_name, email, created_at, created_by, changed_at, changed_by)
+		VALUES (NEW.id, CASE WHEN OLD.deleted_at IS NULL THEN 'update' ELSE 'insert' END,
+			NEW.first_name, NEW.last_name, NEW.email, NEW.created_at, NEW.created_by, NEW.updated_at, NEW.updated_by);
+	END;
+	CREATE TRIGGER person_changes_delete AFTER UPDATE ON people WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL BEGIN
+		INSERT INTO person_changes (person_id, operation, changed_at, changed_by) VALUES (NEW.id, 'delete', NEW.updated_at, NEW.updated_by);
+	END;
+	CREATE TRIGGER person_changes_purge AFTER DELETE ON people WHEN OLD.deleted_at IS NULL BEGIN
+		INSERT INTO person_changes (person_id, operation, changed_at, changed_by)
+		VALUES (OLD.id, 'delete', CAST(unixepoch('subsec') * 1e9 AS INTEGER), '');
+	END;`,
+}
+
+// SchemaVersion is the schema version this build expects
+var SchemaVersion = len(migrations)
+
+// GetSchemaVersion returns the schema version of the connected database
+func GetSchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := DB.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// migrate applies the migrations the database hasn't seen yet, each in its
+// own transaction along with the version bump
+func migrate() error {
+	version, err := GetSchemaVersion(context.Background())
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, SchemaVersion)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		slog.Info("Applying schema migration", "version", i+1)
+
+		tx, err := DB.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		// PRAGMA arguments can't be bound parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
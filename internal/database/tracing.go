@@ -0,0 +1,31 @@
+package database
+
+import (
+	"context"
+
+	"github.com/atrakic/gin-sqlite/internal/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the query spans, children of the request span in ctx
+var tracer = otel.Tracer("github.com/atrakic/gin-sqlite/internal/database")
+
+// startQuery starts a span and a latency timer for the named query; the
+// returned function ends both
+func startQuery(ctx context.Context, name, statement string) (context.Context, func()) {
+	ctx, span := tracer.Start(ctx, "db."+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", "sqlite"),
+			attribute.String("db.operation.name", name),
+			attribute.String("db.query.text", statement),
+		))
+	observe := metrics.TimeQuery(name)
+
+	return ctx, func() {
+		observe()
+		span.End()
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package health
+
+import "errors"
+
+// DiskFree is not implemented on this platform
+func DiskFree(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,93 @@
+// Package health runs the readiness checks reported by /readyz
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Check statuses
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is a named readiness check; Run returns a short human readable
+// detail on success and an error when the dependency is not ready
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) (string, error)
+}
+
+// Result is the outcome of a single check
+// @Description Readiness check result
+type Result struct {
+	Name      string  `json:"name" xml:"name" example:"database"`                  // Check name
+	Status    string  `json:"status" xml:"status" example:"ok"`                    // ok or fail
+	LatencyMs float64 `json:"latency_ms" xml:"latency_ms" example:"0.42"`          // Time taken by the check
+	Detail    string  `json:"detail,omitempty" xml:"detail,omitempty"`             // Extra information on success
+	Error     string  `json:"error,omitempty" xml:"error,omitempty" example:"..."` // Failure reason
+} // @name HealthCheckResult
+
+// Report is the outcome of all checks
+// @Description Readiness report
+type Report struct {
+	Status    string    `json:"status" xml:"status" example:"ok"` // ok when every check passed
+	Timestamp time.Time `json:"timestamp" xml:"timestamp"`        // When the checks ran
+	Checks    []Result  `json:"checks" xml:"checks"`              // Individual check results
+} // @name HealthReport
+
+// Checker runs a set of checks concurrently, each bounded by Timeout
+type Checker struct {
+	Checks  []Check
+	Timeout time.Duration
+}
+
+// Run executes every check and aggregates the results
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{
+		Status:    StatusOK,
+		Timestamp: time.Now().UTC(),
+		Checks:    make([]Result, len(c.Checks)),
+	}
+
+	var wg sync.WaitGroup
+	for i, check := range c.Checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Checks[i] = c.run(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	detail, err := check.Run(ctx)
+	result := Result{
+		Name:      check.Name,
+		Status:    StatusOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		Detail:    detail,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,158 @@
+// Package maintenance runs the database maintenance jobs on cron schedules
+// and records each run in the job history
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/robfig/cron/v3"
+)
+
+// Names of the maintenance jobs
+const (
+	JobCheckpoint     = "checkpoint"
+	JobVacuum         = "vacuum"
+	JobOptimize       = "optimize"
+	JobIntegrityCheck = "integrity_check"
+	JobPurgeDeleted   = "purge_deleted"
+)
+
+// Job is a maintenance task run on a cron schedule; Run returns a short
+// description of what it did
+type Job struct {
+	Name     string
+	Schedule string
+	Run      func(ctx context.Context) (string, error)
+}
+
+// Checkpoint copies the write-ahead log into the database file and truncates it
+func Checkpoint(ctx context.Context) (string, error) {
+	frames, err := database.Checkpoint(ctx)
+	return fmt.Sprintf("%d WAL frames", frames), err
+}
+
+// Vacuum returns the free pages of the database file to the file system
+func Vacuum(ctx context.Context) (string, error) {
+	freed, switched, err := database.IncrementalVacuum(ctx)
+	detail := fmt.Sprintf("%d pages freed", freed)
+	if switched {
+		detail = "switched to incremental auto vacuum, " + detail
+	}
+	return detail, err
+}
+
+// Optimize analyzes the tables whose statistics are missing or out of date
+func Optimize(ctx context.Context) (string, error) {
+	return "", database.Optimize(ctx)
+}
+
+// IntegrityCheck fails when the database file is corrupt
+func IntegrityCheck(ctx context.Context) (string, error) {
+	problems, err := database.IntegrityCheck(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(problems) > 0 {
+		return "", fmt.Errorf("%d integrity problems: %s", len(problems), strings.Join(problems, "; "))
+	}
+	return "ok", nil
+}
+
+// PurgeDeleted returns a job permanently removing the persons deleted more
+// than retention ago
+func PurgeDeleted(retention time.Duration) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		purged, err := database.DbPurgeDeletedPersons(ctx, time.Now().Add(-retention))
+		return fmt.Sprintf("%d deleted persons purged", purged), err
+	}
+}
+
+// Scheduler runs jobs on their schedules, one run of each at a time
+type Scheduler struct {
+	cron *cron.Cron
+}
+
+// NewScheduler schedules jobs, leaving out those without a schedule
+func NewScheduler(jobs []Job) (*Scheduler, error) {
+	logger := cronLogger{}
+	c := cron.New(cron.WithLogger(logger), cron.WithChain(cron.Recover(logger)))
+
+	for _, job := range jobs {
+		if job.Schedule == "" {
+			continue
+		}
+		schedule, err := cron.ParseStandard(job.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("scheduling %s: %w", job.Name, err)
+		}
+		c.Schedule(schedule, cron.NewChain(cron.SkipIfStillRunning(logger)).Then(cron.FuncJob(func() {
+			_ = Run(context.Background(), job)
+		})))
+	}
+	return &Scheduler{cron: c}, nil
+}
+
+// Start starts running the jobs in the background
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop stops scheduling jobs and waits for running ones until ctx is done
+func (s *Scheduler) Stop(ctx context.Context) error {
+	select {
+	case <-s.cron.Stop().Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run runs job once and records the run in the job history. Jobs are
+// skipped in maintenance mode, which leaves the database file alone.
+func Run(ctx context.Context, job Job) error {
+	if on, _ := database.InMaintenance(); on {
+		slog.InfoContext(ctx, "Maintenance job skipped in maintenance mode", "job", job.Name)
+		return nil
+	}
+
+	started := time.Now()
+	detail, err := job.Run(ctx)
+	run := models.JobRun{
+		Job:      job.Name,
+		Started:  started.UTC(),
+		Duration: time.Since(started),
+		Status:   models.JobRunOK,
+		Detail:   detail,
+	}
+
+	if err != nil {
+		run.Status, run.Detail = models.JobRunFailed, err.Error()
+		slog.ErrorContext(ctx, "Maintenance job failed", "job", job.Name, "duration", run.Duration, "error", err)
+	} else {
+		slog.InfoContext(ctx, "Maintenance job finished", "job", job.Name, "duration", run.Duration, "detail", detail)
+	}
+
+	if recordErr := database.DbAddJobRun(ctx, run); recordErr != nil {
+		slog.WarnContext(ctx, "Recording maintenance job run failed", "job", job.Name, "error", recordErr)
+	}
+	return err
+}
+
+// cronLogger sends the scheduler's messages to slog
+type cronLogger struct{}
+
+// Info logs at debug level, as the scheduler reports every wake up
+func (cronLogger) Info(msg string, keysAndValues ...any) {
+	slog.Debug("cron: "+msg, keysAndValues...)
+}
+
+// Error logs at error level
+func (cronLogger) Error(err error, msg string, keysAndValues ...any) {
+	slog.Error("cron: "+msg, append(keysAndValues, "error", err)...)
+}
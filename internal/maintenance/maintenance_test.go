@@ -0,0 +1,116 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atrakic/gin-sqlite/internal/database"
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupDatabase(t *testing.T) {
+	require.NoError(t, database.ConnectDatabase(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { _ = database.CloseDatabase() })
+	require.NoError(t, database.InitializeDatabase())
+}
+
+func TestJobs(t *testing.T) {
+	setupDatabase(t)
+	ctx := t.Context()
+
+	// Leave free pages behind for the vacuum
+	for i := range 200 {
+		_, err := database.DB.ExecContext(ctx, "INSERT INTO people (first_name, last_name, email) VALUES ('A', ?, ?)",
+			strings.Repeat("x", 500), fmt.Sprintf("a%d@example.com", i))
+		require.NoError(t, err)
+	}
+	_, err := database.DB.ExecContext(ctx, "DELETE FROM people WHERE first_name = 'A'")
+	require.NoError(t, err)
+
+	detail, err := Checkpoint(ctx)
+	require.NoError(t, err)
+	assert.NotEqual(t, "0 WAL frames", detail)
+
+	detail, err = Vacuum(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "switched to incremental auto vacuum, 0 pages freed", detail, "VACUUM frees the pages when switching")
+
+	for i := range 200 {
+		_, err := database.DB.ExecContext(ctx, "INSERT INTO people (first_name, last_name, email) VALUES ('A', ?, ?)",
+			strings.Repeat("x", 500), fmt.Sprintf("a%d@example.com", i))
+		require.NoError(t, err)
+	}
+	_, err = database.DB.ExecContext(ctx, "DELETE FROM people WHERE first_name = 'A'")
+	require.NoError(t, err)
+
+	detail, err = Vacuum(ctx)
+	require.NoError(t, err)
+	assert.NotEqual(t, "0 pages freed", detail)
+	assert.NotContains(t, detail, "switched")
+	var free int
+	require.NoError(t, database.DB.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&free))
+	assert.Zero(t, free)
+
+	_, err = Optimize(ctx)
+	require.NoError(t, err)
+
+	detail, err = IntegrityCheck(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", detail)
+}
+
+func TestRunRecordsHistory(t *testing.T) {
+	setupDatabase(t)
+	ctx := t.Context()
+
+	require.NoError(t, Run(ctx, Job{Name: JobOptimize, Run: Optimize}))
+	err := Run(ctx, Job{Name: "broken", Run: func(context.Context) (string, error) {
+		return "", errors.New("boom")
+	}})
+	require.EqualError(t, err, "boom")
+
+	runs, err := database.DbGetJobRuns(ctx, "", 10)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, "broken", runs[0].Job)
+	assert.Equal(t, models.JobRunFailed, runs[0].Status)
+	assert.Equal(t, "boom", runs[0].Detail)
+	assert.Equal(t, JobOptimize, runs[1].Job)
+	assert.Equal(t, models.JobRunOK, runs[1].Status)
+	assert.WithinDuration(t, time.Now(), runs[1].Started, time.Minute)
+
+	runs, err = database.DbGetJobRuns(ctx, JobOptimize, 10)
+	require.NoError(t, err)
+	assert.Len(t, runs, 1)
+}
+
+func TestScheduler(t *testing.T) {
+	setupDatabase(t)
+
+	_, err := NewScheduler([]Job{{Name: JobOptimize, Schedule: "every minute", Run: Optimize}})
+	require.ErrorContains(t, err, JobOptimize)
+
+	s, err := NewScheduler([]Job{
+		{Name: JobOptimize, Schedule: "@every 1s", Run: Optimize},
+		{Name: JobVacuum, Run: Vacuum},
+	})
+	require.NoError(t, err)
+	s.Start()
+
+	require.Eventually(t, func() bool {
+		runs, err := database.DbGetJobRuns(context.Background(), JobOptimize, 1)
+		return err == nil && len(runs) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	require.NoError(t, s.Stop(t.Context()))
+
+	runs, err := database.DbGetJobRuns(t.Context(), JobVacuum, 1)
+	require.NoError(t, err)
+	assert.Empty(t, runs, "unscheduled")
+}
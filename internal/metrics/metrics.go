@@ -0,0 +1,119 @@
+// Package metrics defines the Prometheus metrics exposed at /metrics
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every metric of the server, along with the Go runtime and
+// process collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Database query latency by query name.",
+		Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"query"})
+
+	// Logins counts login attempts by result, "success" or "failure"
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_logins_total",
+		Help: "Login attempts by result.",
+	}, []string{"result"})
+
+	// RateLimited counts requests rejected by the rate limiter
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_rate_limited_requests_total",
+		Help: "Requests rejected with 429 by method and route.",
+	}, []string{"method", "route"})
+
+	// DeprecatedRequests counts requests to deprecated API routes
+	DeprecatedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_deprecated_requests_total",
+		Help: "Requests to deprecated API routes by method and route.",
+	}, []string{"method", "route"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		dbQueryDuration,
+		Logins,
+		DeprecatedRequests,
+		RateLimited,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry}))
+}
+
+// Middleware records the count and latency of every request by route
+// template, so /person/1 and /person/2 share a series
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// TimeQuery starts timing the named query; call the returned function once
+// the query is done, typically with defer metrics.TimeQuery("name")()
+func TimeQuery(name string) func() {
+	start := time.Now()
+	return func() {
+		dbQueryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}
+}
+
+// NewDBStatsCollector exports sql.DBStats connection pool metrics for the
+// database returned by db at scrape time, which may change on reconnect
+func NewDBStatsCollector(name string, db func() *sql.DB) prometheus.Collector {
+	return &dbStatsCollector{name: name, db: db}
+}
+
+type dbStatsCollector struct {
+	name string
+	db   func() *sql.DB
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	// The descriptions don't depend on the database, only on its name
+	collectors.NewDBStatsCollector(nil, c.name).Describe(ch)
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	if db := c.db(); db != nil {
+		collectors.NewDBStatsCollector(db, c.name).Collect(ch)
+	}
+}
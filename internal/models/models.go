@@ -1,60 +1,199 @@
 // Package models defines the API models and DTOs for Swagger documentation
 package models
 
-import "github.com/golang-jwt/jwt/v5"
+import (
+	"encoding/xml"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// PersonFields lists the person fields clients may select with ?fields=,
+// each one matching both its JSON name and its column in the people table
+var PersonFields = []string{"id", "first_name", "last_name", "email", "deleted_at", "created_at", "updated_at", "created_by", "updated_by"}
 
 // Person represents a person in the database
 // @Description Person information
 // @ID Person
 type Person struct {
-	ID        uint64 `json:"id" example:"1" format:"uint64"`                      // Person ID
-	FirstName string `json:"first_name" example:"John" maxLength:"50"`            // First name
-	LastName  string `json:"last_name" example:"Doe" maxLength:"50"`              // Last name
-	Email     string `json:"email" example:"john.doe@example.com" format:"email"` // Email address
+	ID        uint64     `json:"id" xml:"id" example:"1" format:"uint64"`                         // Person ID
+	FirstName string     `json:"first_name" xml:"first_name" example:"John" maxLength:"50"`       // First name
+	LastName  string     `json:"last_name" xml:"last_name" example:"Doe" maxLength:"50"`          // Last name
+	Email     string     `json:"email" xml:"email" example:"john.doe@example.com" format:"email"` // Email address
+	DeletedAt *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty" readonly:"true"` // When the person was deleted, only listed with include_deleted
+	CreatedAt time.Time  `json:"created_at" xml:"created_at" readonly:"true"`                     // When the person was created
+	UpdatedAt time.Time  `json:"updated_at" xml:"updated_at" readonly:"true"`                     // When the person was last changed, including deletes and restores
+	CreatedBy string     `json:"created_by,omitempty" xml:"created_by,omitempty" readonly:"true"` // User who created the person, empty for sample data
+	UpdatedBy string     `json:"updated_by,omitempty" xml:"updated_by,omitempty" readonly:"true"` // User who last changed the person
 } // @name Person
 
+// PersonRevision represents a version of a person, recorded on every write
+// @Description Person revision
+type PersonRevision struct {
+	Revision  int64      `json:"revision" xml:"revision" example:"7"`                                             // Revision number, increasing across all persons
+	PersonID  uint64     `json:"person_id" xml:"person_id" example:"1" format:"uint64"`                           // Person ID
+	Operation string     `json:"operation" xml:"operation" example:"update" enums:"insert,update,delete,restore"` // Write that created the revision
+	ChangedAt time.Time  `json:"changed_at" xml:"changed_at"`                                                     // When the write happened
+	FirstName string     `json:"first_name" xml:"first_name" example:"John"`                                      // First name
+	LastName  string     `json:"last_name" xml:"last_name" example:"Doe"`                                         // Last name
+	Email     string     `json:"email" xml:"email" example:"john.doe@example.com"`                                // Email address
+	DeletedAt *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`                                 // When the person was deleted, on delete revisions
+	ChangedBy string     `json:"changed_by,omitempty" xml:"changed_by,omitempty"`                                 // User who made the write
+} // @name PersonRevision
+
+// Person returns the person as of the revision, leaving out when and by
+// whom it was created
+func (r PersonRevision) Person() Person {
+	return Person{
+		ID:        r.PersonID,
+		FirstName: r.FirstName,
+		LastName:  r.LastName,
+		Email:     r.Email,
+		DeletedAt: r.DeletedAt,
+		UpdatedAt: r.ChangedAt,
+		UpdatedBy: r.ChangedBy,
+	}
+}
+
+// PersonChange represents an entry of the person change feed
+// @Description Person change, a tombstone without the person on deletes
+type PersonChange struct {
+	Seq       int64     `json:"seq" xml:"seq" example:"42"`                                              // Sequence number, increasing across all changes
+	Operation string    `json:"operation" xml:"operation" example:"update" enums:"insert,update,delete"` // Kind of change; restored persons are inserted again
+	PersonID  uint64    `json:"person_id" xml:"person_id" example:"1" format:"uint64"`                   // Person ID
+	ChangedAt time.Time `json:"changed_at" xml:"changed_at"`                                             // When the change happened
+	ChangedBy string    `json:"changed_by,omitempty" xml:"changed_by,omitempty"`                         // User who made the change
+	Person    *Person   `json:"person,omitempty" xml:"person,omitempty"`                                 // Person after the change, on inserts and updates
+} // @name PersonChange
+
+// Project returns only the given fields of the person, keyed by JSON name
+func (p Person) Project(fields []string) Record {
+	record := make(Record, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "id":
+			record[field] = p.ID
+		case "first_name":
+			record[field] = p.FirstName
+		case "last_name":
+			record[field] = p.LastName
+		case "email":
+			record[field] = p.Email
+		case "deleted_at":
+			record[field] = p.DeletedAt
+		case "created_at":
+			record[field] = p.CreatedAt
+		case "updated_at":
+			record[field] = p.UpdatedAt
+		case "created_by":
+			record[field] = p.CreatedBy
+		case "updated_by":
+			record[field] = p.UpdatedBy
+		}
+	}
+	return record
+}
+
+// Record is a partial resource holding only the fields a client asked for
+type Record map[string]interface{}
+
+// MarshalXML encodes the record as one child element per field, sorted by name
+func (r Record) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(r))
+	for key := range r {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := e.EncodeElement(r[key], xml.StartElement{Name: xml.Name{Local: key}}); err != nil {
+			return err
+		}
+	}
+
+	return e.EncodeToken(start.End())
+}
+
 // CreatePersonRequest represents the request body for creating a person
 // @Description Request body for creating a new person
 type CreatePersonRequest struct {
-	FirstName string `json:"first_name" binding:"required" example:"John" maxLength:"50"`                  // First name (required)
-	LastName  string `json:"last_name" binding:"required" example:"Doe" maxLength:"50"`                    // Last name (required)
-	Email     string `json:"email" binding:"required,email" example:"john.doe@example.com" format:"email"` // Email address (required)
+	FirstName string `json:"first_name" xml:"first_name" binding:"required" example:"John" maxLength:"50"`             // First name (required)
+	LastName  string `json:"last_name" xml:"last_name" binding:"required" example:"Doe" maxLength:"50"`                // Last name (required)
+	Email     string `json:"email" xml:"email" binding:"required,email" example:"john.doe@example.com" format:"email"` // Email address (required)
 } // @name CreatePersonRequest
 
 // UpdatePersonRequest represents the request body for updating a person
 // @Description Request body for updating an existing person
 type UpdatePersonRequest struct {
-	FirstName string `json:"first_name,omitempty" example:"Jane" maxLength:"50"`              // First name (optional)
-	LastName  string `json:"last_name,omitempty" example:"Smith" maxLength:"50"`              // Last name (optional)
-	Email     string `json:"email,omitempty" example:"jane.smith@example.com" format:"email"` // Email address (optional)
+	FirstName string `json:"first_name,omitempty" xml:"first_name,omitempty" example:"Jane" maxLength:"50"`         // First name (optional)
+	LastName  string `json:"last_name,omitempty" xml:"last_name,omitempty" example:"Smith" maxLength:"50"`          // Last name (optional)
+	Email     string `json:"email,omitempty" xml:"email,omitempty" example:"jane.smith@example.com" format:"email"` // Email address (optional)
 } // @name UpdatePersonRequest
 
-// APIResponse represents a generic API response
-// @Description Generic API response
+// APIResponse is the envelope wrapping every API response
+// @Description Response envelope shared by all endpoints
 type APIResponse struct {
-	Data    interface{} `json:"data,omitempty"`    // Response data
-	Message string      `json:"message,omitempty"` // Response message
-	Error   string      `json:"error,omitempty"`   // Error message
+	Data     interface{} `json:"data,omitempty" xml:"data,omitempty"`         // Response data
+	Meta     Meta        `json:"meta" xml:"meta"`                             // Response metadata
+	Links    *Links      `json:"links,omitempty" xml:"links,omitempty"`       // Links to this and related pages
+	Included Record      `json:"included,omitempty" xml:"included,omitempty"` // Related resources requested with ?include=
+	Message  string      `json:"message,omitempty" xml:"message,omitempty"`   // Response message
+	Error    string      `json:"error,omitempty" xml:"error,omitempty"`       // Error message
 } // @name APIResponse
 
-// HealthCheckResponse represents the health check response
-// @Description Health check response
-type HealthCheckResponse struct {
-	Message string `json:"message" example:"pong 1697123456"` // Health check message with timestamp
-} // @name HealthCheckResponse
+// PaginatedResponse is the body of API v1 list responses, which predate the
+// envelope and keep their shape
+// @Description Paginated response of API v1
+type PaginatedResponse struct {
+	Data       interface{}    `json:"data" xml:"data"`                             // Response data
+	Included   Record         `json:"included,omitempty" xml:"included,omitempty"` // Related resources requested with ?include=
+	Pagination PaginationMeta `json:"pagination" xml:"pagination"`                 // Pagination metadata
+} // @name PaginatedResponse
+
+// V1Response is the body of every other API v1 response, which predate the
+// envelope and keep their shape
+// @Description Response of API v1
+type V1Response struct {
+	Data     interface{} `json:"data,omitempty" xml:"data,omitempty"`         // Response data
+	Included Record      `json:"included,omitempty" xml:"included,omitempty"` // Related resources requested with ?include=
+	Message  string      `json:"message,omitempty" xml:"message,omitempty"`   // Response message
+	Error    string      `json:"error,omitempty" xml:"error,omitempty"`       // Error message
+} // @name V1Response
+
+// Meta represents the response envelope metadata
+// @Description Response metadata
+type Meta struct {
+	APIVersion string          `json:"api_version" xml:"api_version" example:"v1"`      // API version that produced the response
+	Pagination *PaginationMeta `json:"pagination,omitempty" xml:"pagination,omitempty"` // Pagination metadata, on list responses only
+} // @name Meta
+
+// Links represents the response envelope links, also sent as RFC 8288 Link headers
+// @Description Links to the current resource and adjacent pages
+type Links struct {
+	Self  string `json:"self" xml:"self" example:"/api/v1/person?page=2&page_size=10"`                       // This resource
+	First string `json:"first,omitempty" xml:"first,omitempty" example:"/api/v1/person?page=1&page_size=10"` // First page
+	Prev  string `json:"prev,omitempty" xml:"prev,omitempty" example:"/api/v1/person?page=1&page_size=10"`   // Previous page
+	Next  string `json:"next,omitempty" xml:"next,omitempty" example:"/api/v1/person?page=3&page_size=10"`   // Next page
+	Last  string `json:"last,omitempty" xml:"last,omitempty" example:"/api/v1/person?page=5&page_size=10"`   // Last page
+} // @name Links
 
 // LoginRequest represents the login request body
 // @Description Login request body
 type LoginRequest struct {
-	Username string `json:"username" binding:"required" example:"admin"`  // Username (required)
-	Password string `json:"password" binding:"required" example:"secret"` // Password (required)
+	Username string `json:"username" xml:"username" binding:"required" example:"admin"`  // Username (required)
+	Password string `json:"password" xml:"password" binding:"required" example:"secret"` // Password (required)
 } // @name LoginRequest
 
 // LoginResponse represents the login response body
 // @Description Login response body
 type LoginResponse struct {
-	Token     string `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."` // JWT token
-	ExpiresAt int64  `json:"expires_at" example:"1697209856"`                         // Token expiration timestamp
+	Token     string `json:"token" xml:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."` // JWT token
+	ExpiresAt int64  `json:"expires_at" xml:"expires_at" example:"1697209856"`                    // Token expiration timestamp
 } // @name LoginResponse
 
 // JWTClaims represents the JWT token claims
@@ -66,24 +205,55 @@ type JWTClaims struct {
 // PaginationRequest represents pagination query parameters
 // @Description Pagination request parameters
 type PaginationRequest struct {
-	Page     int `form:"page" json:"page" example:"1" minimum:"1"`                          // Page number (starting from 1)
-	PageSize int `form:"page_size" json:"page_size" example:"10" minimum:"1" maximum:"100"` // Number of items per page
+	Page     int `form:"page" json:"page" xml:"page" example:"1" minimum:"1"`                               // Page number (starting from 1)
+	PageSize int `form:"page_size" json:"page_size" xml:"page_size" example:"10" minimum:"1" maximum:"100"` // Number of items per page
 } // @name PaginationRequest
 
 // PaginationMeta represents pagination metadata
 // @Description Pagination metadata information
 type PaginationMeta struct {
-	CurrentPage int   `json:"current_page" example:"1"`      // Current page number
-	PageSize    int   `json:"page_size" example:"10"`        // Number of items per page
-	TotalPages  int   `json:"total_pages" example:"5"`       // Total number of pages
-	TotalItems  int64 `json:"total_items" example:"50"`      // Total number of items
-	HasNextPage bool  `json:"has_next_page" example:"true"`  // Whether there is a next page
-	HasPrevPage bool  `json:"has_prev_page" example:"false"` // Whether there is a previous page
+	CurrentPage int   `json:"current_page" xml:"current_page" example:"1"`       // Current page number
+	PageSize    int   `json:"page_size" xml:"page_size" example:"10"`            // Number of items per page
+	TotalPages  int   `json:"total_pages" xml:"total_pages" example:"5"`         // Total number of pages
+	TotalItems  int64 `json:"total_items" xml:"total_items" example:"50"`        // Total number of items
+	HasNextPage bool  `json:"has_next_page" xml:"has_next_page" example:"true"`  // Whether there is a next page
+	HasPrevPage bool  `json:"has_prev_page" xml:"has_prev_page" example:"false"` // Whether there is a previous page
 } // @name PaginationMeta
 
-// PaginatedResponse represents a paginated API response
-// @Description Paginated API response
-type PaginatedResponse struct {
-	Data       interface{}    `json:"data"`       // Response data
-	Pagination PaginationMeta `json:"pagination"` // Pagination metadata
-} // @name PaginatedResponse
+// ImportResult represents the outcome of a bulk import
+// @Description Bulk import summary
+type ImportResult struct {
+	Imported int `json:"imported" xml:"imported" example:"2"` // Number of persons created
+	Skipped  int `json:"skipped" xml:"skipped" example:"1"`   // Number of entries skipped (missing or duplicate email)
+} // @name ImportResult
+
+// JobRun represents a run of a database maintenance job
+// @Description Maintenance job run
+type JobRun struct {
+	ID       int64         `json:"id" xml:"id" example:"1"`                                               // Run ID
+	Job      string        `json:"job" xml:"job" example:"checkpoint"`                                    // Job name
+	Started  time.Time     `json:"started" xml:"started"`                                                 // When the run started
+	Duration time.Duration `json:"duration_ns" xml:"duration_ns" example:"1500000" swaggertype:"integer"` // Run time in nanoseconds
+	Status   string        `json:"status" xml:"status" example:"ok" enums:"ok,failed"`                    // Outcome
+	Detail   string        `json:"detail" xml:"detail" example:"WAL truncated"`                           // What the job did, or why it failed
+} // @name JobRun
+
+// Job run statuses
+const (
+	JobRunOK     = "ok"
+	JobRunFailed = "failed"
+)
+
+// MaintenanceStatus represents the state of the read-only maintenance mode
+// @Description Maintenance mode status
+type MaintenanceStatus struct {
+	Enabled    bool       `json:"enabled" xml:"enabled" example:"true"`                           // Whether writes are rejected
+	Since      *time.Time `json:"since,omitempty" xml:"since,omitempty"`                          // When maintenance mode was entered
+	RetryAfter int        `json:"retry_after,omitempty" xml:"retry_after,omitempty" example:"60"` // Seconds clients are told to wait before retrying writes
+} // @name MaintenanceStatus
+
+// MaintenanceRequest represents the body entering maintenance mode
+// @Description Maintenance mode request
+type MaintenanceRequest struct {
+	RetryAfter int `json:"retry_after" xml:"retry_after" binding:"omitempty,min=1" example:"300"` // Seconds clients are told to wait before retrying writes, default from the configuration
+} // @name MaintenanceRequest
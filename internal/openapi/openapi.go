@@ -0,0 +1,64 @@
+// Package openapi embeds the OpenAPI spec generated from the handler
+// annotations and serves it along with the Swagger UI
+package openapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files"
+	ginSwagger "github.com/swaggo/gin-swagger"
+)
+
+// Regenerate the spec after changing annotations; TestSpecUpToDate fails
+// until then. Keep the flags in sync with swagArgs in the test.
+//go:generate go tool swag init --dir ../.. --generalInfo cmd/server/main.go --pd --parseInternal --outputTypes json --output .
+
+// Spec is the generated swagger.json
+//
+//go:embed swagger.json
+var Spec []byte
+
+// SpecPath is where the spec is served
+const SpecPath = "/docs/swagger.json"
+
+// assetMaxAge is how long browsers may cache the versioned Swagger UI assets
+const assetMaxAge = 24 * time.Hour
+
+// SpecHandler serves the spec, letting clients revalidate their copy by ETag
+func SpecHandler() gin.HandlerFunc {
+	sum := sha256.Sum256(Spec)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-cache")
+		c.Header("ETag", etag)
+		// ServeContent answers If-None-Match with 304 and handles ranges
+		http.ServeContent(c.Writer, c.Request, "swagger.json", time.Time{}, bytes.NewReader(Spec))
+	}
+}
+
+// UIHandler serves the Swagger UI, embedded in the binary by swaggo/files,
+// pointed at the spec. The UI assets are cached for a day, the index page
+// is revalidated so it follows the spec location.
+func UIHandler() gin.HandlerFunc {
+	ui := ginSwagger.WrapHandler(swaggerFiles.Handler, ginSwagger.URL(SpecPath))
+	maxAge := "public, max-age=" + strconv.Itoa(int(assetMaxAge.Seconds()))
+
+	return func(c *gin.Context) {
+		switch c.Param("any") {
+		// Rendered from the configuration rather than versioned with the bundle
+		case "/", "/index.html", "/index.css", "/doc.json", "/swagger-initializer.js":
+			c.Header("Cache-Control", "no-cache")
+		default:
+			c.Header("Cache-Control", maxAge)
+		}
+		ui(c)
+	}
+}
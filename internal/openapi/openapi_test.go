@@ -0,0 +1,66 @@
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// swagArgs are the go:generate flags, writing to a directory of the test's
+var swagArgs = []string{"tool", "swag", "init", "--dir", "../..", "--generalInfo", "cmd/server/main.go", "--pd", "--parseInternal", "--outputTypes", "json"}
+
+func TestSpecUpToDate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("regenerating the spec parses the whole module")
+	}
+
+	dir := t.TempDir()
+	cmd := exec.Command("go", append(swagArgs, "--output", dir)...)
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	generated, err := os.ReadFile(filepath.Join(dir, "swagger.json"))
+	require.NoError(t, err)
+	assert.JSONEq(t, string(generated), string(Spec), "swagger.json is out of date, run go generate ./internal/openapi")
+}
+
+func TestSpecHandlerCaching(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET(SpecPath, SpecHandler())
+	r.GET("/swagger/*any", UIHandler())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", SpecPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, Spec, w.Body.Bytes())
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", SpecPath, nil)
+	req.Header.Set("If-None-Match", etag)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.Bytes())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/swagger/index.html", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	assert.Contains(t, w.Body.String(), "swagger-ui")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/swagger/swagger-ui-bundle.js", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "public, max-age=86400", w.Header().Get("Cache-Control"))
+}
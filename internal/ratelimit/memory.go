@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps buckets in memory, they are lost on restart
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]Bucket
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]Bucket)}
+}
+
+// Update implements Store
+func (s *MemoryStore) Update(_ context.Context, key string, fn func(Bucket, bool) Bucket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, found := s.buckets[key]
+	s.buckets[key] = fn(b, found)
+	return nil
+}
+
+// Prune implements Store
+func (s *MemoryStore) Prune(_ context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, b := range s.buckets {
+		if !b.FullAt.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+	return nil
+}
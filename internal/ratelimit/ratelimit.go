@@ -0,0 +1,193 @@
+// Package ratelimit implements token bucket rate limiting with pluggable
+// bucket storage
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Requests per Period, in bursts of up to Requests; the zero
+// Limit is unlimited
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit parses a limit written as "<requests>/<period>", such as "10/1m";
+// the empty string is unlimited
+func ParseLimit(s string) (Limit, error) {
+	if s == "" {
+		return Limit{}, nil
+	}
+	requests, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid limit %q, expected <requests>/<period>", s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q, requests must be a positive integer", s)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q, period must be a positive duration", s)
+	}
+	return Limit{Requests: n, Period: d}, nil
+}
+
+// ParseRoutes parses per-route limits written as "<method> <route>=<limit>",
+// such as "POST /auth/login=5/1m", keyed by "<method> <route>"
+func ParseRoutes(entries []string) (map[string]Limit, error) {
+	routes := make(map[string]Limit, len(entries))
+	for _, entry := range entries {
+		route, limit, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid route limit %q, expected <method> <route>=<limit>", entry)
+		}
+		method, path, ok := strings.Cut(strings.TrimSpace(route), " ")
+		if !ok || method == "" || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid route limit %q, expected <method> <route>=<limit>", entry)
+		}
+		l, err := ParseLimit(strings.TrimSpace(limit))
+		if err != nil {
+			return nil, err
+		}
+		routes[strings.ToUpper(method)+" "+path] = l
+	}
+	return routes, nil
+}
+
+// Unlimited reports whether the limit lets every request through
+func (l Limit) Unlimited() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+// Bucket is the stored state of a token bucket
+type Bucket struct {
+	Tokens  float64
+	Updated time.Time
+	// FullAt is when the bucket will have refilled, after which it may be
+	// dropped since a missing bucket counts as full
+	FullAt time.Time
+}
+
+// Store keeps the buckets of all clients
+type Store interface {
+	// Update applies fn to the bucket of key, found reports whether it
+	// existed, and saves the result atomically
+	Update(ctx context.Context, key string, fn func(b Bucket, found bool) Bucket) error
+	// Prune drops the buckets that are full at now
+	Prune(ctx context.Context, now time.Time) error
+}
+
+// Result describes the outcome of a request against its limit
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+	// Reset is the time until the bucket has fully refilled
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, zero when
+	// this one was
+	RetryAfter time.Duration
+}
+
+// pruneInterval is how often the limiter drops full buckets from the store
+const pruneInterval = time.Minute
+
+// Limiter applies per-route limits, falling back to a default one
+type Limiter struct {
+	store  Store
+	def    Limit
+	routes map[string]Limit
+
+	mu        sync.Mutex
+	nextPrune time.Time
+	now       func() time.Time
+}
+
+// NewLimiter creates a limiter keeping its buckets in store, with routes
+// keyed by "<method> <route>" as returned by ParseRoutes
+func NewLimiter(store Store, def Limit, routes map[string]Limit) *Limiter {
+	return &Limiter{store: store, def: def, routes: routes, now: time.Now}
+}
+
+// LimitFor returns the limit of a route
+func (l *Limiter) LimitFor(method, route string) Limit {
+	if limit, ok := l.routes[method+" "+route]; ok {
+		return limit
+	}
+	return l.def
+}
+
+// Allow takes a token from the bucket of client on the given route
+func (l *Limiter) Allow(ctx context.Context, method, route, client string) (Result, error) {
+	limit := l.LimitFor(method, route)
+	if limit.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+
+	now := l.now()
+	l.prune(ctx, now)
+
+	var result Result
+	err := l.store.Update(ctx, method+" "+route+"|"+client, func(b Bucket, found bool) Bucket {
+		var next Bucket
+		next, result = take(limit, b, found, now)
+		return next
+	})
+	if err != nil {
+		return Result{Allowed: true, Limit: limit}, err
+	}
+	return result, nil
+}
+
+// prune drops full buckets at most once per pruneInterval
+func (l *Limiter) prune(ctx context.Context, now time.Time) {
+	l.mu.Lock()
+	due := now.After(l.nextPrune)
+	if due {
+		l.nextPrune = now.Add(pruneInterval)
+	}
+	l.mu.Unlock()
+
+	if due {
+		// Failing to prune only leaves stale buckets behind
+		_ = l.store.Prune(ctx, now)
+	}
+}
+
+// take refills the bucket for the time elapsed since its last update and
+// removes a token if one is available
+func take(limit Limit, b Bucket, found bool, now time.Time) (Bucket, Result) {
+	capacity := float64(limit.Requests)
+	rate := capacity / limit.Period.Seconds()
+
+	tokens := capacity
+	if found {
+		tokens = math.Min(capacity, b.Tokens+now.Sub(b.Updated).Seconds()*rate)
+	}
+
+	result := Result{Limit: limit}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / rate)
+	}
+
+	fullIn := seconds((capacity - tokens) / rate)
+	result.Remaining = int(tokens)
+	result.Reset = fullIn
+
+	return Bucket{Tokens: tokens, Updated: now, FullAt: now.Add(fullIn)}, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
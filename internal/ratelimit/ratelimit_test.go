@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("10/1m")
+	require.NoError(t, err)
+	assert.Equal(t, Limit{Requests: 10, Period: time.Minute}, limit)
+
+	limit, err = ParseLimit("")
+	require.NoError(t, err)
+	assert.True(t, limit.Unlimited())
+
+	for _, s := range []string{"10", "0/1m", "x/1m", "10/soon", "10/-1s"} {
+		_, err := ParseLimit(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes([]string{"post /auth/login=5/1m", "GET /api/v1/person = 100/1s"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Limit{
+		"POST /auth/login":   {Requests: 5, Period: time.Minute},
+		"GET /api/v1/person": {Requests: 100, Period: time.Second},
+	}, routes)
+
+	for _, entry := range []string{"/auth/login=5/1m", "POST auth=5/1m", "POST /auth/login"} {
+		_, err := ParseRoutes([]string{entry})
+		assert.Error(t, err, entry)
+	}
+}
+
+func TestLimiterTokenBucket(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+
+	limiter := NewLimiter(NewMemoryStore(), Limit{Requests: 3, Period: 3 * time.Second}, map[string]Limit{
+		"GET /ping": {},
+	})
+	limiter.now = func() time.Time { return now }
+
+	for i := 2; i >= 0; i-- {
+		result, err := limiter.Allow(ctx, "GET", "/person", "ip:a")
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, i, result.Remaining)
+	}
+
+	result, err := limiter.Allow(ctx, "GET", "/person", "ip:a")
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+	assert.Equal(t, 3*time.Second, result.Reset)
+
+	// Other clients and routes have their own buckets, unlimited routes none
+	result, _ = limiter.Allow(ctx, "GET", "/person", "ip:b")
+	assert.True(t, result.Allowed)
+	result, _ = limiter.Allow(ctx, "POST", "/person", "ip:a")
+	assert.True(t, result.Allowed)
+	result, _ = limiter.Allow(ctx, "GET", "/ping", "ip:a")
+	assert.True(t, result.Allowed)
+	assert.True(t, result.Limit.Unlimited())
+
+	// One token refills per second
+	now = now.Add(time.Second)
+	result, _ = limiter.Allow(ctx, "GET", "/person", "ip:a")
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	// Full buckets are pruned
+	now = now.Add(time.Hour)
+	_, _ = limiter.Allow(ctx, "GET", "/person", "ip:c")
+	store := limiter.store.(*MemoryStore)
+	assert.Len(t, store.buckets, 1)
+}
@@ -0,0 +1,236 @@
+// Package vcard implements vCard 4.0 (RFC 6350) serialisation of persons
+package vcard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/atrakic/gin-sqlite/internal/models"
+)
+
+// MIMEType is the media type for vCard documents
+const MIMEType = "text/vcard"
+
+// maxLineLength is the line length in octets after which content lines are folded
+const maxLineLength = 75
+
+// ErrMalformed is returned when the input is not a well-formed vCard stream
+var ErrMalformed = errors.New("malformed vCard")
+
+// Encode writes the given persons as a stream of vCard 4.0 objects
+func Encode(w io.Writer, persons ...models.Person) error {
+	bw := bufio.NewWriter(w)
+	for _, p := range persons {
+		lines := []string{
+			"BEGIN:VCARD",
+			"VERSION:4.0",
+			"FN:" + escape(strings.TrimSpace(p.FirstName+" "+p.LastName)),
+			"N:" + escape(p.LastName) + ";" + escape(p.FirstName) + ";;;",
+			"EMAIL:" + escape(p.Email),
+			"END:VCARD",
+		}
+		for _, line := range lines {
+			if _, err := bw.WriteString(fold(line)); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Decode reads a stream of vCard objects and maps FN, N and EMAIL into persons
+func Decode(r io.Reader) ([]models.Person, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		persons []models.Person
+		current *card
+	)
+
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		name, value, ok := parseLine(line)
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid content line %q", ErrMalformed, line)
+		}
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCARD"):
+			if current != nil {
+				return nil, fmt.Errorf("%w: nested BEGIN:VCARD", ErrMalformed)
+			}
+			current = &card{}
+		case name == "END" && strings.EqualFold(value, "VCARD"):
+			if current == nil {
+				return nil, fmt.Errorf("%w: END:VCARD without BEGIN", ErrMalformed)
+			}
+			persons = append(persons, current.person())
+			current = nil
+		case current == nil:
+			return nil, fmt.Errorf("%w: property %s outside of vCard", ErrMalformed, name)
+		case name == "FN":
+			current.fn = unescape(value)
+		case name == "N":
+			current.n = splitComponents(value)
+		case name == "EMAIL":
+			// Keep the first address, vCards may carry several
+			if current.email == "" {
+				current.email = unescape(value)
+			}
+		}
+	}
+
+	if current != nil {
+		return nil, fmt.Errorf("%w: missing END:VCARD", ErrMalformed)
+	}
+
+	return persons, nil
+}
+
+// card holds the properties of a single vCard we care about
+type card struct {
+	fn    string
+	n     []string
+	email string
+}
+
+// person converts the card into a person, falling back to FN when N is absent
+func (c *card) person() models.Person {
+	p := models.Person{Email: c.email}
+
+	if len(c.n) > 1 {
+		p.LastName = c.n[0]
+		p.FirstName = c.n[1]
+	} else if len(c.n) == 1 {
+		p.LastName = c.n[0]
+	}
+
+	if p.FirstName == "" && p.LastName == "" {
+		first, last, _ := strings.Cut(strings.TrimSpace(c.fn), " ")
+		p.FirstName = first
+		p.LastName = strings.TrimSpace(last)
+	}
+
+	return p
+}
+
+// parseLine splits a content line into its upper-cased property name and value,
+// dropping any group prefix and parameters
+func parseLine(line string) (string, string, bool) {
+	head, value, ok := cutUnquoted(line, ':')
+	if !ok {
+		return "", "", false
+	}
+
+	name, _, _ := strings.Cut(head, ";")
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "" {
+		return "", "", false
+	}
+
+	return strings.ToUpper(name), value, true
+}
+
+// cutUnquoted is strings.Cut that ignores separators inside quoted parameter values
+func cutUnquoted(s string, sep byte) (string, string, bool) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				return s[:i], s[i+1:], true
+			}
+		}
+	}
+	return s, "", false
+}
+
+// splitComponents splits a structured value on unescaped semicolons
+func splitComponents(value string) []string {
+	var (
+		parts []string
+		b     strings.Builder
+	)
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value):
+			b.WriteByte(value[i])
+			b.WriteByte(value[i+1])
+			i++
+		case value[i] == ';':
+			parts = append(parts, unescape(b.String()))
+			b.Reset()
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return append(parts, unescape(b.String()))
+}
+
+// unfold reads content lines, joining folded continuation lines
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+// fold splits a content line into CRLF terminated chunks of at most 75 octets
+// without breaking UTF-8 sequences
+func fold(line string) string {
+	var b strings.Builder
+	limit := maxLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space that counts against the limit
+		limit = maxLineLength - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+var (
+	escaper   = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`)
+	unescaper = strings.NewReplacer(`\\`, `\`, `\,`, ",", `\;`, ";", `\n`, "\n", `\N`, "\n")
+)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
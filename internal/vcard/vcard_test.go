@@ -0,0 +1,65 @@
+package vcard
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/atrakic/gin-sqlite/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	persons := []models.Person{
+		{FirstName: "John", LastName: "Doe", Email: "john.doe@example.com"},
+		{FirstName: "Anne, Marie", LastName: "O;Neil\\Smith", Email: "anne@example.com"},
+		{FirstName: strings.Repeat("Ä", 60), LastName: "Long", Email: "long@example.com"},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, persons...))
+
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		assert.LessOrEqual(t, len(line), maxLineLength, "line should be folded")
+	}
+
+	decoded, err := Decode(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, persons, decoded)
+}
+
+func TestDecodeThirdParty(t *testing.T) {
+	input := "BEGIN:VCARD\r\n" +
+		"VERSION:4.0\r\n" +
+		"item1.EMAIL;TYPE=work;PREF=1:jane.smith@example.com\r\n" +
+		"EMAIL;TYPE=home:jane@home.example.com\r\n" +
+		"FN:Jane Smith\r\n" +
+		"N:Smith;Jane;;Dr.;\r\n" +
+		"TEL;VALUE=uri;TYPE=\"voice,home\":tel:+1-555-555-5555\r\n" +
+		"END:VCARD\r\n" +
+		"BEGIN:VCARD\n" +
+		"VERSION:4.0\n" +
+		"fn:Bob\n" +
+		"  Johnson\n" +
+		"email:bob@example.com\n" +
+		"END:VCARD\n"
+
+	persons, err := Decode(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, persons, 2)
+
+	assert.Equal(t, models.Person{FirstName: "Jane", LastName: "Smith", Email: "jane.smith@example.com"}, persons[0])
+	assert.Equal(t, models.Person{FirstName: "Bob", LastName: "Johnson", Email: "bob@example.com"}, persons[1])
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for _, input := range []string{
+		"FN:No card\r\n",
+		"BEGIN:VCARD\r\nFN:Unterminated\r\n",
+		"BEGIN:VCARD\r\nnot a content line\r\nEND:VCARD\r\n",
+	} {
+		_, err := Decode(strings.NewReader(input))
+		assert.ErrorIs(t, err, ErrMalformed, input)
+	}
+}
@@ -30,7 +30,7 @@ function test_login() {
     --data '{"username":"'"$ADMIN_USER"'", "password":"'"$ADMIN_PASSWORD"'"}' \
     http://"$URL"/auth/login)
 
-  JWT_TOKEN=$(echo "$response" | jq -r '.token')
+  JWT_TOKEN=$(echo "$response" | jq -r '.data.token')
 
   if [ -z "$JWT_TOKEN" ] || [ "$JWT_TOKEN" == "null" ]; then
     echo "❌ Login failed or token not found"
@@ -57,7 +57,7 @@ function test_create_person() {
 
   # Extract person ID from response if available
   local person_id
-  person_id=$(echo "$response" | jq -r '.id // empty' 2>/dev/null || echo "")
+  person_id=$(echo "$response" | jq -r '.data.id // empty' 2>/dev/null || echo "")
   if [ -n "$person_id" ]; then
     echo "🆔 Created person ID: $person_id"
     echo "$person_id"
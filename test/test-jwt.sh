@@ -7,7 +7,7 @@ echo "🚀 Starting JWT Authentication Test..."
 
 # Start server in background
 echo "📝 Starting server on port 8083..."
-PORT=8083 go run cmd/server/main.go &
+PORT=8083 DATABASE_FILE="${DATABASE_FILE:-$(mktemp -d)/database.db}" go run ./cmd/server &
 SERVER_PID=$!
 
 # Wait for server to start
@@ -23,7 +23,7 @@ echo "📋 Login response: $LOGIN_RESPONSE"
 
 # Extract token (assuming JSON response)
 if command -v jq &> /dev/null; then
-    TOKEN=$(echo "$LOGIN_RESPONSE" | jq -r '.token')
+    TOKEN=$(echo "$LOGIN_RESPONSE" | jq -r '.data.token')
     echo "🎯 Extracted token: ${TOKEN:0:50}..."
 
     # Test authenticated endpoint